@@ -2,7 +2,9 @@ package ConfigFile
 
 import (
 	"io/ioutil"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"superpose-sync/utils"
@@ -12,9 +14,12 @@ import (
 )
 
 type WatchPath struct {
+	ID        string  `yaml:"id,omitempty"`
 	Path      string  `yaml:"dir"`
 	Recursive *bool   `yaml:"recursive"`
 	Mask      *string `yaml:"mask"`
+	// Symlinks is how symbolic links are synced: preserve, follow or skip
+	Symlinks string `yaml:"symlinks,omitempty"`
 }
 
 type GoogleDrive struct {
@@ -22,6 +27,48 @@ type GoogleDrive struct {
 	ClientId     string `yaml:"client_id"`
 	ClientSecret string `yaml:"client_secret"`
 	Token        Token  `yaml:"token"`
+	// RequestsPerSecond is the budget of requests sent to Google
+	RequestsPerSecond float64 `yaml:"requests_per_second,omitempty"`
+	// ChunkSizeMB is the size of each request of resumable uploads, files
+	// bigger than it are sent in chunks
+	ChunkSizeMB int `yaml:"chunk_size_mb,omitempty"`
+}
+
+// LocalDir is a directory used as remote, e.g. a mounted NAS share
+type LocalDir struct {
+	Path string `yaml:"path"`
+}
+
+// S3 is a bucket on AWS S3 or any compatible object storage (MinIO, Backblaze B2...)
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region,omitempty"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix,omitempty"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	// Insecure uses plain http, e.g. for a local MinIO
+	Insecure bool `yaml:"insecure,omitempty"`
+}
+
+// WebDAV is a WebDAV server, like Nextcloud or ownCloud
+type WebDAV struct {
+	URL      string `yaml:"url"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// SFTP is a directory on an SSH host
+type SFTP struct {
+	// Host is "host" or "host:port"
+	Host       string `yaml:"host"`
+	User       string `yaml:"user"`
+	KeyPath    string `yaml:"key_path,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	KnownHosts string `yaml:"known_hosts,omitempty"`
+	// InsecureIgnoreHostKey skips checking the host key, only for tests
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key,omitempty"`
+	Root                  string `yaml:"root"`
 }
 
 type Token struct {
@@ -34,30 +81,191 @@ type Token struct {
 }
 
 type ConfigsStruct struct {
-	GoogleDrive GoogleDrive `yaml:"google_drive"`
-	Mask        string      `yaml:"mask"`
-	ConfigPath  string      `yaml:"config_path"`
-	DbPath      string      `yaml:"db"`
-	WatchPaths  []WatchPath `yaml:"watchers,flow"`
-	IgnorePaths []WatchPath `yaml:"ignore,flow"`
+	Remote         string       `yaml:"remote,omitempty"`
+	GoogleDrive    GoogleDrive  `yaml:"google_drive"`
+	Local          LocalDir     `yaml:"local,omitempty"`
+	S3             S3           `yaml:"s3,omitempty"`
+	WebDAV         WebDAV       `yaml:"webdav,omitempty"`
+	SFTP           SFTP         `yaml:"sftp,omitempty"`
+	Mask           string       `yaml:"mask"`
+	ConfigPath     string       `yaml:"config_path"`
+	DbPath         string       `yaml:"db"`
+	PollInterval   string       `yaml:"poll_interval,omitempty"`
+	RescanInterval string       `yaml:"rescan_interval,omitempty"`
+	Debounce       string       `yaml:"debounce,omitempty"`
+	QuarantinePath string       `yaml:"quarantine_path,omitempty"`
+	ConflictPolicy string       `yaml:"conflict_policy,omitempty"`
+	PathScheme     string       `yaml:"path_scheme,omitempty"`
+	Workers        int          `yaml:"workers,omitempty"`
+	WatchPaths     []WatchPath  `yaml:"watchers,flow"`
+	IgnorePaths    []IgnorePath `yaml:"ignore,flow"`
 }
 
+const defaultPollInterval = 30 * time.Second
+
+const defaultRescanInterval = 10 * time.Minute
+
+const defaultDebounce = time.Second
+
+const defaultRemote = "google_drive"
+
+const defaultWorkers = 4
+
+const defaultDriveRequestsPerSecond = 10
+
+const defaultDriveChunkSizeMB = 8
+
+const (
+	ConflictKeepBoth     = "keep-both"
+	ConflictPreferLocal  = "prefer-local"
+	ConflictPreferRemote = "prefer-remote"
+	ConflictNewestWins   = "newest-wins"
+)
+
+const (
+	// SymlinksPreserve syncs the link itself, with its target
+	SymlinksPreserve = "preserve"
+	// SymlinksFollow syncs what the link points to, as if it was there
+	SymlinksFollow = "follow"
+	SymlinksSkip   = "skip"
+)
+
 var (
 	Configs ConfigsStruct
 	Info    os.FileInfo
 )
 
-func isDirectory(path string) (bool, error) {
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		if os.IsNotExist(err) && strings.HasSuffix(path, "*") {
-			return false, nil
-		}
-		//if errors.Is(err, )
-		return false, err
+// GetRemote returns the name of the storage backend files are synced to.
+func GetRemote() string {
+	if Configs.Remote == "" {
+		return defaultRemote
+	}
+	return Configs.Remote
+}
+
+// RemoteScope identifies where files are synced to: the remote and its
+// root, e.g. "google_drive:[root_folder_id]" or "s3:[endpoint]/[bucket]/[prefix]".
+func RemoteScope() string {
+	remote := GetRemote()
+	location := ""
+	switch remote {
+	case "google_drive":
+		location = Configs.GoogleDrive.RootFolderId
+	case "local":
+		location = filepath.Clean(utils.GetAbsPathLocal(Configs.Local.Path))
+	case "s3":
+		location = Configs.S3.Endpoint + "/" + Configs.S3.Bucket + "/" + strings.Trim(Configs.S3.Prefix, "/")
+	case "webdav":
+		location = strings.TrimSuffix(Configs.WebDAV.URL, "/")
+	case "sftp":
+		location = Configs.SFTP.User + "@" + Configs.SFTP.Host + ":" + path.Clean("/"+Configs.SFTP.Root)
+	}
+	return remote + ":" + location
+}
+
+// GetWorkers returns how many remote operations run at the same time.
+func GetWorkers() int {
+	if Configs.Workers == 0 {
+		return defaultWorkers
+	}
+	if Configs.Workers < 0 {
+		log.Printf("invalid workers %d, using %d", Configs.Workers, defaultWorkers)
+		return defaultWorkers
+	}
+	return Configs.Workers
+}
+
+// GetDriveRequestsPerSecond returns how many requests are sent to Google
+// per second at most.
+func GetDriveRequestsPerSecond() float64 {
+	requestsPerSecond := Configs.GoogleDrive.RequestsPerSecond
+	if requestsPerSecond == 0 {
+		return defaultDriveRequestsPerSecond
+	}
+	if requestsPerSecond < 0 {
+		log.Printf("invalid requests_per_second %v, using %d", requestsPerSecond, defaultDriveRequestsPerSecond)
+		return defaultDriveRequestsPerSecond
+	}
+	return requestsPerSecond
+}
+
+// GetDriveChunkSize returns the size in bytes of each chunk of resumable
+// uploads.
+func GetDriveChunkSize() int64 {
+	chunkSizeMB := Configs.GoogleDrive.ChunkSizeMB
+	if chunkSizeMB < 0 {
+		log.Printf("invalid chunk_size_mb %d, using %d", chunkSizeMB, defaultDriveChunkSizeMB)
+		chunkSizeMB = 0
+	}
+	if chunkSizeMB == 0 {
+		chunkSizeMB = defaultDriveChunkSizeMB
+	}
+	// Any size in MB is a multiple of the 256KB Drive requires
+	return int64(chunkSizeMB) * 1024 * 1024
+}
+
+// GetPollInterval returns how often the remote is checked for changes.
+func GetPollInterval() time.Duration {
+	if Configs.PollInterval == "" {
+		return defaultPollInterval
+	}
+
+	interval, err := time.ParseDuration(Configs.PollInterval)
+	if err != nil || interval <= 0 {
+		log.Printf("invalid poll_interval %q, using %s", Configs.PollInterval, defaultPollInterval)
+		return defaultPollInterval
+	}
+
+	return interval
+}
+
+// GetRescanInterval returns how often remotes without a changes feed are
+// listed and compared with the watched dirs.
+func GetRescanInterval() time.Duration {
+	if Configs.RescanInterval == "" {
+		return defaultRescanInterval
+	}
+
+	interval, err := time.ParseDuration(Configs.RescanInterval)
+	if err != nil || interval <= 0 {
+		log.Printf("invalid rescan_interval %q, using %s", Configs.RescanInterval, defaultRescanInterval)
+		return defaultRescanInterval
+	}
+
+	return interval
+}
+
+// GetDebounce returns how long a path must be quiet before its events are
+// synced, 0 syncs them right away.
+func GetDebounce() time.Duration {
+	if Configs.Debounce == "" {
+		return defaultDebounce
 	}
 
-	return fileInfo.IsDir(), err
+	debounce, err := time.ParseDuration(Configs.Debounce)
+	if err != nil || debounce < 0 {
+		log.Printf("invalid debounce %q, using %s", Configs.Debounce, defaultDebounce)
+		return defaultDebounce
+	}
+
+	return debounce
+}
+
+// GetSymlinks returns how symbolic links inside localPath watcher are
+// synced. The deepest watcher wins, as on WatcherPath.
+func GetSymlinks(localPath string) string {
+	watchPath, ok := findWatcher(localPath)
+	if !ok || watchPath.Symlinks == "" {
+		return SymlinksPreserve
+	}
+
+	switch watchPath.Symlinks {
+	case SymlinksPreserve, SymlinksFollow, SymlinksSkip:
+		return watchPath.Symlinks
+	}
+
+	log.Printf("invalid symlinks %q on %q, using %s", watchPath.Symlinks, watchPath.Path, SymlinksPreserve)
+	return SymlinksPreserve
 }
 
 func ParseFile(configFile string) error {
@@ -98,30 +306,37 @@ func SaveFile() error {
 	return nil
 }
 
-func PathInIgnore(pathToCheck string) (bool, error) {
-	for _, path := range Configs.IgnorePaths {
-		strPath, err := utils.GetAbsPath(path.Path)
-		if err != nil {
-			return true, err
-		}
+// GetConflictPolicy returns how a file changed on both sides is resolved.
+func GetConflictPolicy() string {
+	switch Configs.ConflictPolicy {
+	case ConflictKeepBoth, ConflictPreferLocal, ConflictPreferRemote, ConflictNewestWins:
+		return Configs.ConflictPolicy
+	case "":
+		return ConflictKeepBoth
+	}
+
+	log.Printf("invalid conflict_policy %q, using %s", Configs.ConflictPolicy, ConflictKeepBoth)
+	return ConflictKeepBoth
+}
 
-		needToAddWildcard, err := isDirectory(strPath)
+// PathInWatchers reports whether pathToCheck is inside one of the watched dirs.
+func PathInWatchers(pathToCheck string) (bool, error) {
+	for _, path := range Configs.WatchPaths {
+		strPath, err := utils.GetAbsPath(path.Path)
 		if err != nil {
-			return true, err
+			return false, err
 		}
 
-		if needToAddWildcard {
-			strPath += "/**/*"
+		relPath, err := filepath.Rel(strPath, pathToCheck)
+		if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+			continue
 		}
 
-		isIgnored, err := filepath.Match(strPath, pathToCheck)
-		if err != nil {
-			return true, err
+		if path.Recursive != nil && !*path.Recursive && strings.Contains(relPath, "/") {
+			continue
 		}
 
-		if isIgnored {
-			return true, nil
-		}
+		return true, nil
 	}
 	return false, nil
 }
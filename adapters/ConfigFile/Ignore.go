@@ -0,0 +1,293 @@
+package ConfigFile
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/utils"
+	"sync"
+)
+
+// IgnoreFileName is the file with gitignore-style patterns, relative to
+// its dir, that any watched dir can have.
+const IgnoreFileName = ".superposeignore"
+
+// IgnorePath is an entry of "ignore" on watchers.yml. Dir is a path, like
+// "~/.kube/cache/", and Pattern a gitignore pattern relative to each
+// watched dir, like "*.swp" or "node_modules/". Both can start with "!" to
+// re-include what an earlier entry ignored.
+type IgnorePath struct {
+	Path    string `yaml:"dir,omitempty"`
+	Pattern string `yaml:"pattern,omitempty"`
+}
+
+type ignoreRule struct {
+	// segments are matched against the path segments, "**" matches any
+	// number of them
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// ignoreRules are the rules of an ignore file or of an "ignore" entry,
+// matched against paths relative to base. An empty base is the root of
+// the watcher of each path.
+type ignoreRules struct {
+	base  string
+	rules []ignoreRule
+}
+
+var (
+	ignoreFilesMutex sync.Mutex
+	// ignoreFiles are the rules of the ignore file of each dir, loaded
+	// when first needed
+	ignoreFiles = map[string][]ignoreRule{}
+)
+
+// parseIgnoreRule parses a line with gitignore syntax. Blank lines and
+// comments return false.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	rule := ignoreRule{}
+
+	// Trailing spaces are kept only when escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns with a slash are anchored to the base, the others match
+	// at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return rule, false
+	}
+
+	if !anchored {
+		rule.segments = append(rule.segments, "**")
+	}
+	for _, segment := range strings.Split(line, "/") {
+		if segment == "" {
+			continue
+		}
+		if _, err := filepath.Match(segment, ""); err != nil {
+			log.Printf("invalid ignore pattern %q: %v", line, err)
+			return rule, false
+		}
+		rule.segments = append(rule.segments, segment)
+	}
+
+	return rule, true
+}
+
+// match reports whether the rule matches relPath, a path relative to the
+// base of the rule.
+func (rule ignoreRule) match(relPath string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(rule.segments, strings.Split(relPath, "/"))
+}
+
+func matchSegments(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			// A trailing "**" matches what is inside, not the dir itself
+			if len(pattern) == 0 {
+				return len(path) > 0
+			}
+
+			for i := range path {
+				if matchSegments(pattern, path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+		ok, err := filepath.Match(pattern[0], path[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// configIgnoreRules parses the "ignore" entries of watchers.yml.
+func configIgnoreRules() ([]ignoreRules, error) {
+	rulesList := []ignoreRules{}
+	for _, ignorePath := range Configs.IgnorePaths {
+		line := ignorePath.Pattern
+		base := ""
+
+		if ignorePath.Path != "" {
+			negate := strings.HasPrefix(ignorePath.Path, "!")
+			strPath, err := utils.GetAbsPath(strings.TrimPrefix(ignorePath.Path, "!"))
+			if err != nil {
+				return nil, err
+			}
+
+			// Relative dirs are relative to each watched dir, like
+			// patterns
+			line = strPath
+			if filepath.IsAbs(strPath) {
+				base = "/"
+			} else {
+				line = "/" + line
+			}
+			if negate {
+				line = "!" + line
+			}
+		}
+
+		rule, ok := parseIgnoreRule(line)
+		if !ok {
+			continue
+		}
+		rulesList = append(rulesList, ignoreRules{base: base, rules: []ignoreRule{rule}})
+	}
+	return rulesList, nil
+}
+
+// ignoreFileRules returns the rules of the ignore file of dir, none when
+// it doesn't have one.
+func ignoreFileRules(dir string) []ignoreRule {
+	ignoreFilesMutex.Lock()
+	defer ignoreFilesMutex.Unlock()
+
+	if rules, ok := ignoreFiles[dir]; ok {
+		return rules
+	}
+
+	var rules []ignoreRule
+	content, err := os.ReadFile(filepath.Join(dir, IgnoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("error reading %q: %v", filepath.Join(dir, IgnoreFileName), err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	ignoreFiles[dir] = rules
+	return rules
+}
+
+// ReloadIgnoreFiles forgets the ignore files of dir and of the dirs below
+// it, they are read again when needed. It's called when they change.
+func ReloadIgnoreFiles(dir string) {
+	ignoreFilesMutex.Lock()
+	defer ignoreFilesMutex.Unlock()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for path := range ignoreFiles {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(ignoreFiles, path)
+		}
+	}
+}
+
+// PathInIgnore reports whether pathToCheck is ignored by the "ignore"
+// entries of watchers.yml or by the ignore files of its watcher, with
+// gitignore semantics: the last matching rule wins, deeper ignore files
+// win over the upper ones and over watchers.yml, and what is inside an
+// ignored dir is ignored, whatever its own rules say.
+func PathInIgnore(pathToCheck string) (bool, error) {
+	configRules, err := configIgnoreRules()
+	if err != nil {
+		return true, err
+	}
+
+	pathToCheck = filepath.Clean(pathToCheck)
+	root := ""
+	if watchPath, ok := findWatcher(pathToCheck); ok {
+		root, _ = utils.GetAbsPath(watchPath.Path)
+		root = filepath.Clean(root)
+	}
+
+	isDir := false
+	if info, err := os.Stat(pathToCheck); err == nil {
+		isDir = info.IsDir()
+	}
+
+	parts := strings.Split(strings.TrimPrefix(pathToCheck, "/"), "/")
+	for i := range parts {
+		path := "/" + strings.Join(parts[:i+1], "/")
+		last := i == len(parts)-1
+		if ignoredByRules(path, !last || isDir, root, configRules) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ignoredByRules applies to path the rules of watchers.yml and then the
+// ignore files from root down to its parent.
+func ignoredByRules(path string, isDir bool, root string, configRules []ignoreRules) bool {
+	rulesList := append([]ignoreRules{}, configRules...)
+
+	if relPath, ok := relativeTo(root, path); ok {
+		dir := root
+		for _, segment := range strings.Split(relPath, "/") {
+			rulesList = append(rulesList, ignoreRules{base: dir, rules: ignoreFileRules(dir)})
+			dir = filepath.Join(dir, segment)
+		}
+	}
+
+	ignored := false
+	for _, rules := range rulesList {
+		base := rules.base
+		if base == "" {
+			base = root
+		}
+
+		relPath, ok := relativeTo(base, path)
+		if !ok {
+			continue
+		}
+
+		for _, rule := range rules.rules {
+			if rule.match(relPath, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// relativeTo returns path relative to base when it's below base.
+func relativeTo(base string, path string) (string, bool) {
+	if base == "" {
+		return "", false
+	}
+
+	prefix := strings.TrimSuffix(base, "/") + "/"
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	return path[len(prefix):], true
+}
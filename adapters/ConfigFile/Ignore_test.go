@@ -0,0 +1,103 @@
+package ConfigFile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPathInIgnore(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	Configs = ConfigsStruct{
+		WatchPaths: []WatchPath{{Path: root}},
+		IgnorePaths: []IgnorePath{
+			{Path: filepath.Join(root, "cache")},
+			{Pattern: "*.log"},
+		},
+	}
+	ReloadIgnoreFiles(root)
+
+	writeFile(t, filepath.Join(root, IgnoreFileName), "# comment\n"+
+		"*.swp\n"+
+		"!important.swp\n"+
+		"node_modules/\n"+
+		"/build\n"+
+		"docs/**/*.md\n"+
+		"keep\n"+
+		"!keep/x\n"+
+		"!debug.log\n")
+	writeFile(t, filepath.Join(root, "src", IgnoreFileName), "!*.swp\n")
+	for _, dir := range []string{"node_modules", "lib/node_modules", "build", "src/build", "keep", "cache"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(root, "node_modules.txt"), "")
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"file.txt", false},
+		{"file.swp", true},
+		{"deep/dir/file.swp", true},
+		{"important.swp", false},
+		{"node_modules", true},
+		{"node_modules/pkg/index.js", true},
+		{"lib/node_modules/pkg", true},
+		{"node_modules.txt", false},
+		{"build", true},
+		{"build/out", true},
+		{"src/build", false},
+		{"docs/a.md", true},
+		{"docs/guide/deep/a.md", true},
+		{"docs/a.txt", false},
+		// What is inside an ignored dir can't be included again
+		{"keep/x", true},
+		// Deeper ignore files win over the upper ones
+		{"src/file.swp", false},
+		{"cache/data", true},
+		{"app.log", true},
+		// Ignore files win over watchers.yml
+		{"debug.log", false},
+	}
+	for _, test := range tests {
+		ignored, err := PathInIgnore(filepath.Join(root, test.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ignored != test.ignored {
+			t.Errorf("PathInIgnore(%q) = %v, want %v", test.path, ignored, test.ignored)
+		}
+	}
+
+	// Patterns apply only inside the watched dirs
+	if ignored, _ := PathInIgnore(filepath.Join(outside, "file.swp")); ignored {
+		t.Errorf("PathInIgnore(%q) = true outside the watched dirs", "file.swp")
+	}
+	if ignored, _ := PathInIgnore(filepath.Join(outside, "app.log")); ignored {
+		t.Errorf("PathInIgnore(%q) = true outside the watched dirs", "app.log")
+	}
+
+	// Changed ignore files are read again once reloaded
+	writeFile(t, filepath.Join(root, "src", IgnoreFileName), "")
+	if ignored, _ := PathInIgnore(filepath.Join(root, "src/file.swp")); ignored {
+		t.Errorf("%s was read again before ReloadIgnoreFiles", IgnoreFileName)
+	}
+	ReloadIgnoreFiles(root)
+	if ignored, _ := PathInIgnore(filepath.Join(root, "src/file.swp")); !ignored {
+		t.Errorf("%s wasn't read again after ReloadIgnoreFiles", IgnoreFileName)
+	}
+}
@@ -0,0 +1,197 @@
+package ConfigFile
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"superpose-sync/utils"
+)
+
+const (
+	PathSchemeAbsolute = "absolute"
+	PathSchemeRelative = "relative"
+)
+
+// RelativePaths reports whether remote paths are saved relative to each
+// watcher, so they match on machines with different users or home dirs.
+func RelativePaths() bool {
+	return Configs.PathScheme == PathSchemeRelative
+}
+
+// GetID returns the watcher identifier used on remote paths. When "id" is
+// not set it's the dir in its "~" form, e.g. "~/.ssh".
+func (watchPath WatchPath) GetID() string {
+	if watchPath.ID != "" {
+		return strings.TrimSuffix(watchPath.ID, "/")
+	}
+
+	root, err := utils.GetAbsPath(watchPath.Path)
+	if err != nil {
+		return filepath.Clean(watchPath.Path)
+	}
+	return homeRelative(root)
+}
+
+// WatcherPath returns the id of the watcher containing localPath and the
+// path relative to its root. The deepest watcher wins.
+func WatcherPath(localPath string) (string, string, bool) {
+	watchPath, ok := findWatcher(localPath)
+	if !ok {
+		return "", "", false
+	}
+
+	root, _ := utils.GetAbsPath(watchPath.Path)
+	relPath, _ := filepath.Rel(filepath.Clean(root), localPath)
+	return watchPath.GetID(), relPath, true
+}
+
+// WatcherRoot returns the watched dir containing localPath, as it's
+// watched. The deepest watcher wins.
+func WatcherRoot(localPath string) (string, bool) {
+	watchPath, ok := findWatcher(localPath)
+	if !ok {
+		return "", false
+	}
+
+	root, err := utils.GetAbsPath(watchPath.Path)
+	return root, err == nil
+}
+
+// findWatcher returns the deepest watcher containing localPath.
+func findWatcher(localPath string) (WatchPath, bool) {
+	var watcher WatchPath
+	deepest := -1
+	for _, watchPath := range Configs.WatchPaths {
+		root, err := utils.GetAbsPath(watchPath.Path)
+		if err != nil {
+			continue
+		}
+		root = filepath.Clean(root)
+
+		rel, err := filepath.Rel(root, localPath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+
+		if len(root) > deepest {
+			deepest = len(root)
+			watcher = watchPath
+		}
+	}
+
+	return watcher, deepest >= 0
+}
+
+// RemotePath returns the form of localPath saved on remote. With the
+// relative path_scheme it is "[watcher id]/[relative path]", or the "~"
+// form outside watchers, otherwise it is localPath itself.
+func RemotePath(localPath string) string {
+	localPath = filepath.Clean(localPath)
+	if !RelativePaths() {
+		return localPath
+	}
+
+	watcherId, relPath, ok := WatcherPath(localPath)
+	if !ok {
+		return homeRelative(localPath)
+	}
+	if relPath == "." {
+		return watcherId
+	}
+	return watcherId + "/" + relPath
+}
+
+// LocalPath translates a path generated by RemotePath, on any machine, to
+// this machine. Absolute paths are returned as they are.
+func LocalPath(remotePath string) string {
+	if strings.HasPrefix(remotePath, utils.REMOTE_PREFIX) {
+		remotePath = strings.TrimPrefix(remotePath, utils.REMOTE_PREFIX)
+	}
+
+	watchPaths := make([]WatchPath, len(Configs.WatchPaths))
+	copy(watchPaths, Configs.WatchPaths)
+	sort.Slice(watchPaths, func(i, j int) bool {
+		return len(watchPaths[i].GetID()) > len(watchPaths[j].GetID())
+	})
+
+	for _, watchPath := range watchPaths {
+		watcherId := watchPath.GetID()
+		if remotePath != watcherId && !strings.HasPrefix(remotePath, watcherId+"/") {
+			continue
+		}
+
+		root, err := utils.GetAbsPath(watchPath.Path)
+		if err != nil {
+			continue
+		}
+		return filepath.Join(root, strings.TrimPrefix(remotePath, watcherId))
+	}
+
+	localPath, err := utils.GetAbsPath(remotePath)
+	if err != nil {
+		return remotePath
+	}
+	return localPath
+}
+
+// LocalPathFromProperties returns the local path saved on remote metadata
+// (Drive appProperties), or an empty string when there is none.
+func LocalPathFromProperties(properties map[string]string) string {
+	watcherId, relPath := properties["watcher"], properties["relPath"]
+	if watcherId != "" && relPath != "" {
+		for _, watchPath := range Configs.WatchPaths {
+			if watchPath.GetID() != watcherId {
+				continue
+			}
+
+			root, err := utils.GetAbsPath(watchPath.Path)
+			if err == nil && isInside(relPath) {
+				return filepath.Join(root, relPath)
+			}
+		}
+	}
+
+	if fullPath := properties["fullPath"]; fullPath != "" {
+		return LocalPath(fullPath)
+	}
+	return ""
+}
+
+// PathProperties returns the path related metadata saved on remote for localPath.
+func PathProperties(localPath string) map[string]string {
+	properties := map[string]string{
+		"fullPath": RemotePath(localPath),
+	}
+
+	if RelativePaths() {
+		watcherId, relPath, ok := WatcherPath(localPath)
+		if ok {
+			properties["watcher"] = watcherId
+			properties["relPath"] = relPath
+		}
+	}
+
+	return properties
+}
+
+func homeRelative(path string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	if path == homeDir {
+		return "~"
+	}
+	if strings.HasPrefix(path, homeDir+"/") {
+		return "~/" + strings.TrimPrefix(path, homeDir+"/")
+	}
+	return path
+}
+
+// isInside reports whether relPath stays inside the dir it's relative to.
+func isInside(relPath string) bool {
+	relPath = filepath.Clean(relPath)
+	return !filepath.IsAbs(relPath) && relPath != ".." && !strings.HasPrefix(relPath, "../")
+}
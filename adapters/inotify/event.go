@@ -124,4 +124,8 @@ func (i InotifyEvent) Is(needle uint32) bool {
 type FileEvent struct {
 	InotifyEvent
 	Eof bool
+	// FromName is where the file was before, on InMovedTo events paired
+	// with their InMovedFrom. It's empty when the file was moved from
+	// outside the watched dirs.
+	FromName string
 }
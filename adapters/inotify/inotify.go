@@ -47,9 +47,19 @@ func (i *Inotify) AddWatch(pathName string, mask uint32) error {
 	}
 
 	i.m.Lock()
+	defer i.m.Unlock()
+
+	// Already watched through another path, e.g. a followed symlink, its
+	// events are sent with the real path
+	if watched, ok := i.rwatches[uint32(w)]; ok && watched != pathName {
+		if realPath, err := filepath.EvalSymlinks(pathName); err != nil || realPath != pathName {
+			return nil
+		}
+		delete(i.watches, watched)
+	}
+
 	i.watches[pathName] = uint32(w)
 	i.rwatches[uint32(w)] = pathName
-	i.m.Unlock()
 
 	//log.Printf("watching: [%d] %s", uint32(w), pathName)
 	return nil
@@ -75,6 +85,22 @@ func (i *Inotify) RmWd(wd uint32) error {
 	return nil
 }
 
+// Forget drops the watch wd, already removed by the kernel.
+func (i *Inotify) Forget(wd uint32) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	pathName, ok := i.rwatches[wd]
+	if !ok {
+		return
+	}
+
+	if i.watches[pathName] == wd {
+		delete(i.watches, pathName)
+	}
+	delete(i.rwatches, wd)
+}
+
 // RmWatch removes watch by pathName
 func (i *Inotify) RmWatch(pathName string) error {
 	log.Printf("Unwatching: %s", pathName)
@@ -119,8 +145,10 @@ func (i *Inotify) Read() ([]InotifyEvent, error) {
 
 		offset += syscall.SizeofInotifyEvent + int(event.Len)
 
+		// The name is relative to the watched dir, it's joined with the
+		// dir path only when the event is handled, since dirs may be
+		// moved meanwhile
 		name := strings.TrimRight(string(namebuf), "\x00")
-		name = filepath.Join(i.rwatches[uint32(event.Wd)], name)
 		//if event.Mask&InIsDir != InIsDir {
 		//	log.Printf("i.Read: {Wd: %d, Cookie: %d, Len: %d, Name: %s, Mask: %s, namebuf: %s, name: %s}",
 		//		uint32(event.Wd), event.Cookie, event.Len, event.Name, InMaskToString(event.Mask), string(namebuf), name)
@@ -136,6 +164,62 @@ func (i *Inotify) Read() ([]InotifyEvent, error) {
 	return events, nil
 }
 
+// Path returns the full path of name, received on an event of the watch wd.
+func (i *Inotify) Path(wd uint32, name string) string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	return filepath.Join(i.rwatches[wd], name)
+}
+
+// Rename updates the paths of pathName and every dir below it, moved to
+// newPathName. The watch descriptors follow moved dirs, only their paths
+// change.
+func (i *Inotify) Rename(pathName string, newPathName string) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	moved := map[string]string{}
+	for path := range i.watches {
+		if newPath, ok := MovedPath(path, pathName, newPathName); ok {
+			moved[path] = newPath
+		}
+	}
+
+	for path, newPath := range moved {
+		wd := i.watches[path]
+		delete(i.watches, path)
+		i.watches[newPath] = wd
+		i.rwatches[wd] = newPath
+	}
+}
+
+// Watches returns the watched dirs at pathName or below it.
+func (i *Inotify) Watches(pathName string) []string {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	paths := []string{}
+	for path := range i.watches {
+		if _, ok := MovedPath(path, pathName, pathName); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// MovedPath returns where path is after oldPath was moved to newPath, and
+// false if path isn't oldPath or below it.
+func MovedPath(path string, oldPath string, newPath string) (string, bool) {
+	if path == oldPath {
+		return newPath, true
+	}
+	if strings.HasPrefix(path, oldPath+"/") {
+		return newPath + strings.TrimPrefix(path, oldPath), true
+	}
+	return "", false
+}
+
 // Close should be called when inotify is no longer needed in order to cleanup used resources.
 func (i *Inotify) Close() error {
 	i.m.Lock()
@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	Watcher "superpose-sync/adapters/ConfigFile"
+	"superpose-sync/utils"
+	"time"
 )
 
 // InotifyWatcher recursively watches the given root folder, waiting for file events.
@@ -81,15 +83,32 @@ func (watcher *InotifyWatcher) InotifyAddWatcher(path string, mask uint32) error
 }
 
 func (watcher *InotifyWatcher) AddWatcher(dir string, recursive bool, fileMask uint32) error {
-	var err error
-
-	i := watcher.i
-
-	err = filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+	symlinks := Watcher.GetSymlinks(dir)
+	err := utils.Walk(dir, symlinks == Watcher.SymlinksFollow, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
+			// Removed while walking, e.g. during a git checkout
+			if os.IsNotExist(err) && path != dir {
+				return nil
+			}
 			return err
 		}
 
+		// Without a watching path their events are never sent
+		if utils.IsSymlink(f) && symlinks == Watcher.SymlinksSkip {
+			return nil
+		}
+
+		// Ignored dirs aren't watched, neither is anything inside them
+		if f.IsDir() && path != dir {
+			isIgnored, err := Watcher.PathInIgnore(path)
+			if err != nil {
+				return err
+			}
+			if isIgnored {
+				return filepath.SkipDir
+			}
+		}
+
 		setWatchingPaths(path, f, recursive, fileMask)
 
 		if !recursive && f.IsDir() && path != dir {
@@ -98,14 +117,17 @@ func (watcher *InotifyWatcher) AddWatcher(dir string, recursive bool, fileMask u
 
 		if f.IsDir() {
 			//log.Printf("walking '%s' | %s", dir, path)
-			return watcher.InotifyAddWatcher(path, InAllEvents)
+			err = watcher.InotifyAddWatcher(path, InAllEvents)
+			if os.IsNotExist(err) && path != dir {
+				return filepath.SkipDir
+			}
+			return err
 		} else {
 			return nil
 		}
 	})
 
 	if err != nil {
-		i.Close()
 		return err
 	}
 
@@ -121,10 +143,15 @@ func setWatchingPaths(path string, f os.FileInfo, recursive bool, fileMask uint3
 	}
 }
 
+// moveTimeout is how long an InMovedFrom waits for its InMovedTo. Without
+// it the file was moved out of the watched dirs.
+const moveTimeout = 200 * time.Millisecond
+
 func (watcher *InotifyWatcher) StartWatch(callback func(event FileEvent)) {
 	done := make(chan bool)
 
 	i := watcher.i
+	raws := make(chan []InotifyEvent)
 	events := make(chan FileEvent)
 
 	go func() {
@@ -132,55 +159,43 @@ func (watcher *InotifyWatcher) StartWatch(callback func(event FileEvent)) {
 			raw, err := i.Read()
 			if err != nil {
 				log.Println("Erro read: ", err)
-				close(events)
+				close(raws)
 				return
 			}
+			raws <- raw
+		}
+	}()
 
-			for _, event := range raw {
-				// Skip ignored events queued from removed watchers.yml
-				if event.Mask&InIgnored == InIgnored {
-					continue
-				}
-
-				// Add watch for folders created in watched folders (recursion)
-				if WatchingPaths[event.Name].Recursive && event.Mask&(InCreate|InIsDir) == InCreate|InIsDir {
-					err = watcher.AddWatcher(event.Name, WatchingPaths[event.Name].Recursive, WatchingPaths[event.Name].Mask)
-					if err != nil {
-						close(events)
-						return
-					}
-				}
+	go func() {
+		// InMovedFrom events waiting for their InMovedTo, by cookie
+		movedFrom := map[uint32]InotifyEvent{}
+		var moveTimer <-chan time.Time
 
-				if event.Mask&InCreate == InCreate && event.Mask&InIsDir != InIsDir {
-					parentEvent := WatchingPaths[filepath.Dir(event.Name)]
-					//log.Println("\nparentEvent.Mask: ", InMaskToString(parentEvent.Mask))
-					fileInfo, err := os.Stat(event.Name)
-					if err != nil {
-						log.Println("Stat error for new file: ", err)
-						close(events)
-						return
-					}
-					setWatchingPaths(event.Name, fileInfo, parentEvent.Recursive, parentEvent.Mask)
+		for {
+			select {
+			case raw, ok := <-raws:
+				if !ok {
+					close(events)
+					return
 				}
 
-				// Remove watch for deleted folders
-				if event.Mask&InDeleteSelf == InDeleteSelf {
-					//err = i.RmWd(event.Wd)
-					err = watcher.RmWatcher(filepath.Dir(event.Name))
+				for _, event := range raw {
+					err := watcher.handle(event, movedFrom, events)
 					if err != nil {
-						log.Println("RmWd 2 error: ", err)
 						close(events)
 						return
 					}
 				}
 
-				// Skip sub-folder events
-				if event.Mask&InIsDir == InIsDir && event.Mask&InCreate != InCreate {
-					continue
+				moveTimer = nil
+				if len(movedFrom) > 0 {
+					moveTimer = time.After(moveTimeout)
 				}
-
-				events <- FileEvent{
-					InotifyEvent: event,
+			case <-moveTimer:
+				moveTimer = nil
+				for cookie, event := range movedFrom {
+					delete(movedFrom, cookie)
+					watcher.movedOut(event, events)
 				}
 			}
 		}
@@ -201,12 +216,6 @@ func (watcher *InotifyWatcher) StartWatch(callback func(event FileEvent)) {
 					return
 				}
 
-				// Skip events not conforming with provided mask
-				fileMask := WatchingPaths[event.Name].Mask
-				if event.Mask&fileMask == 0 {
-					continue
-				}
-
 				watcher.C <- event
 			}
 		}
@@ -232,6 +241,274 @@ func (watcher *InotifyWatcher) StartWatch(callback func(event FileEvent)) {
 	<-done
 }
 
+// handle keeps WatchingPaths up to date with event and sends it to events.
+// Moves are sent once both sides are received, see movedOut.
+func (watcher *InotifyWatcher) handle(event InotifyEvent, movedFrom map[uint32]InotifyEvent, events chan<- FileEvent) error {
+	i := watcher.i
+
+	// The watch was removed, by RmWatch or because its dir was deleted
+	if event.Mask&InIgnored == InIgnored {
+		i.Forget(event.Wd)
+		return nil
+	}
+
+	// Events were lost, wd is -1 and there's no name
+	if event.Mask&InQOverflow == InQOverflow {
+		watcher.overflowed(events)
+		return nil
+	}
+
+	event.Name = i.Path(event.Wd, event.Name)
+
+	if filepath.Base(event.Name) == Watcher.IgnoreFileName && event.Mask&ignoreFileEvents != 0 {
+		watcher.ignoreFileChanged(event.Name)
+	}
+
+	if event.Mask&InMovedFrom == InMovedFrom {
+		movedFrom[event.Cookie] = event
+		return nil
+	}
+
+	if event.Mask&InMovedTo == InMovedTo {
+		from, paired := movedFrom[event.Cookie]
+		delete(movedFrom, event.Cookie)
+
+		if paired {
+			watcher.moved(from.Name, event.Name)
+			watcher.send(FileEvent{InotifyEvent: event, FromName: from.Name}, events)
+			return nil
+		}
+
+		watcher.movedIn(event)
+		watcher.send(FileEvent{InotifyEvent: event}, events)
+		return nil
+	}
+
+	// Add watch for folders created in watched folders (recursion), and
+	// for what was created inside them before it
+	if event.Mask&(InCreate|InIsDir) == InCreate|InIsDir {
+		parentEvent := WatchingPaths[filepath.Dir(event.Name)]
+		if !parentEvent.Recursive {
+			return nil
+		}
+
+		err := watcher.AddWatcher(event.Name, parentEvent.Recursive, parentEvent.Mask)
+		if err != nil {
+			// Already moved or removed
+			log.Printf("error watching %q: %v", event.Name, err)
+			return nil
+		}
+	}
+
+	if event.Mask&InCreate == InCreate && event.Mask&InIsDir != InIsDir {
+		parentEvent := WatchingPaths[filepath.Dir(event.Name)]
+		//log.Println("\nparentEvent.Mask: ", InMaskToString(parentEvent.Mask))
+		if linkInfo, err := os.Lstat(event.Name); err == nil && utils.IsSymlink(linkInfo) {
+			switch Watcher.GetSymlinks(event.Name) {
+			case Watcher.SymlinksSkip:
+				return nil
+			case Watcher.SymlinksFollow:
+				// Linked dirs are watched as if they were there
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && parentEvent.Recursive {
+					err = watcher.AddWatcher(event.Name, parentEvent.Recursive, parentEvent.Mask)
+					if err != nil {
+						log.Printf("error watching %q: %v", event.Name, err)
+					}
+				}
+			}
+		}
+
+		fileInfo, err := os.Stat(event.Name)
+		if err != nil {
+			// Already moved or removed, e.g. a temp file
+			log.Println("Stat error for new file: ", err)
+		}
+		setWatchingPaths(event.Name, fileInfo, parentEvent.Recursive, parentEvent.Mask)
+	}
+
+	// The kernel already removed the watch of deleted folders, their
+	// parent sends the InDelete
+	if event.Mask&InDeleteSelf == InDeleteSelf {
+		return nil
+	}
+
+	// Deleted folders are sent before forgetting them, their mask is
+	// needed
+	if event.Mask&(InDelete|InIsDir) == InDelete|InIsDir {
+		watcher.send(FileEvent{InotifyEvent: event}, events)
+		watcher.forget(event.Name)
+		return nil
+	}
+
+	// Skip other sub-folder events
+	if event.Mask&InIsDir == InIsDir && event.Mask&InCreate != InCreate {
+		return nil
+	}
+
+	watcher.send(FileEvent{InotifyEvent: event}, events)
+	return nil
+}
+
+// ignoreFileEvents are the events that change an ignore file.
+const ignoreFileEvents = InCreate | InCloseWrite | InMove | InDelete
+
+// ignoreFileChanged reloads the ignore file of name's dir and watches the
+// dirs it doesn't ignore anymore.
+func (watcher *InotifyWatcher) ignoreFileChanged(name string) {
+	dir := filepath.Dir(name)
+	Watcher.ReloadIgnoreFiles(dir)
+
+	if watchingPath, ok := WatchingPaths[dir]; ok && watchingPath.Recursive {
+		watcher.rewatch(dir, watchingPath)
+	}
+}
+
+// send skips events not conforming with the mask of their path.
+func (watcher *InotifyWatcher) send(event FileEvent, events chan<- FileEvent) {
+	fileMask := WatchingPaths[event.Name].Mask
+	if event.Mask&fileMask == 0 {
+		return
+	}
+
+	events <- event
+}
+
+// overflowed watches the dirs created while events were lost and sends an
+// InQOverflow event for every watch root, so they are rescanned.
+func (watcher *InotifyWatcher) overflowed(events chan<- FileEvent) {
+	log.Println("inotify queue overflowed, events were lost")
+	// Ignore files may have changed meanwhile
+	Watcher.ReloadIgnoreFiles("/")
+
+	for _, root := range watcher.roots() {
+		watchingPath := WatchingPaths[root]
+		if watchingPath.Recursive {
+			watcher.rewatch(root, watchingPath)
+		}
+
+		events <- FileEvent{InotifyEvent: InotifyEvent{Name: root, Mask: InQOverflow}}
+	}
+}
+
+// roots returns the watched dirs that aren't inside another watched dir.
+func (watcher *InotifyWatcher) roots() []string {
+	roots := []string{}
+	for path, watchingPath := range WatchingPaths {
+		if _, ok := WatchingPaths[filepath.Dir(path)]; ok || watchingPath.FileInfo == nil || !watchingPath.FileInfo.IsDir() {
+			continue
+		}
+		roots = append(roots, path)
+	}
+	return roots
+}
+
+// rewatch adds the dirs below root that aren't watched yet.
+func (watcher *InotifyWatcher) rewatch(root string, rootPath WatchingPath) {
+	watches := map[string]bool{}
+	for _, path := range watcher.i.Watches(root) {
+		watches[path] = true
+	}
+
+	follow := Watcher.GetSymlinks(root) == Watcher.SymlinksFollow
+	err := utils.Walk(root, follow, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			// Removed meanwhile
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !f.IsDir() || watches[path] {
+			return nil
+		}
+
+		isIgnored, err := Watcher.PathInIgnore(path)
+		if err != nil || isIgnored {
+			return filepath.SkipDir
+		}
+
+		err = watcher.AddWatcher(path, rootPath.Recursive, rootPath.Mask)
+		if err != nil {
+			log.Printf("error watching %q: %v", path, err)
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		log.Printf("error rewatching %q: %v", root, err)
+	}
+}
+
+// moved rekeys the watching paths of from, and everything below it, to to.
+func (watcher *InotifyWatcher) moved(from string, to string) {
+	parent := WatchingPaths[filepath.Dir(to)]
+
+	for path, watchingPath := range WatchingPaths {
+		newPath, ok := MovedPath(path, from, to)
+		if !ok {
+			continue
+		}
+
+		delete(WatchingPaths, path)
+		watchingPath.Name = newPath
+		watchingPath.Mask = parent.Mask
+		watchingPath.Recursive = parent.Recursive
+		WatchingPaths[newPath] = watchingPath
+	}
+
+	watcher.i.Rename(from, to)
+	Watcher.ReloadIgnoreFiles(from)
+	Watcher.ReloadIgnoreFiles(to)
+}
+
+// movedIn starts watching what was moved from outside the watched dirs.
+func (watcher *InotifyWatcher) movedIn(event InotifyEvent) {
+	parent := WatchingPaths[filepath.Dir(event.Name)]
+
+	if event.Mask&InIsDir == InIsDir {
+		if !parent.Recursive {
+			setWatchingPaths(event.Name, nil, parent.Recursive, parent.Mask)
+			return
+		}
+
+		err := watcher.AddWatcher(event.Name, parent.Recursive, parent.Mask)
+		if err != nil {
+			log.Printf("error watching %q: %v", event.Name, err)
+		}
+		return
+	}
+
+	fileInfo, err := os.Lstat(event.Name)
+	if err != nil {
+		log.Println("Stat error for moved file: ", err)
+	}
+	setWatchingPaths(event.Name, fileInfo, parent.Recursive, parent.Mask)
+}
+
+// movedOut sends the InMovedFrom of what left the watched dirs, and stops
+// watching it.
+func (watcher *InotifyWatcher) movedOut(event InotifyEvent, events chan<- FileEvent) {
+	watcher.send(FileEvent{InotifyEvent: event}, events)
+	watcher.forget(event.Name)
+
+	for _, path := range watcher.i.Watches(event.Name) {
+		err := watcher.i.RmWatch(path)
+		if err != nil {
+			log.Printf("error unwatching %q: %v", path, err)
+		}
+	}
+}
+
+// forget removes the watching paths of pathName and everything below it.
+func (watcher *InotifyWatcher) forget(pathName string) {
+	for path := range WatchingPaths {
+		if _, ok := MovedPath(path, pathName, pathName); ok {
+			delete(WatchingPaths, path)
+		}
+	}
+	Watcher.ReloadIgnoreFiles(pathName)
+}
+
 func (watcher *InotifyWatcher) Close() {
 	select {
 	case watcher.stopC <- struct{}{}:
@@ -66,8 +66,8 @@ func (c DBConnection) Exec(sql string) (sql.Result, error) {
 	return result, nil
 }
 
-func (c DBConnection) Query(sql string) (*sql.Rows, error) {
-	result, err := c.conn.Query(sql)
+func (c DBConnection) Query(sql string, args ...any) (*sql.Rows, error) {
+	result, err := c.conn.Query(sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +89,11 @@ func (c DBConnection) QueryRow(query string, args ...any) *sql.Row {
 	return result
 }
 
+// Begin starts a transaction, for writes that must be saved together.
+func (c DBConnection) Begin() (*sql.Tx, error) {
+	return c.conn.Begin()
+}
+
 func (c DBConnection) Prepare(query string) (*Stmt, error) {
 	stmt, err := c.conn.Prepare(query)
 	return &Stmt{stmt: stmt}, err
@@ -98,10 +103,18 @@ func Connect() {
 	file = ConfigFile.Configs.DbPath
 	log.Println("Initiating SQLite3 for file ", file)
 
-	db, err := sql.Open("sqlite3", file)
+	// Queue workers, the poller and the remote events listener use the
+	// database at the same time, so writers wait for each other instead
+	// of failing with "database is locked"
+	db, err := sql.Open("sqlite3", file+"?_busy_timeout=10000&_journal_mode=WAL")
 	if err != nil {
 		log.Fatalf("Unable to connect to sqlite file: %v", err)
 	}
 
 	DB = DBConnection{conn: db, debug: false}
+
+	err = Migrate()
+	if err != nil {
+		log.Fatalf("Unable to migrate sqlite file: %v", err)
+	}
 }
@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are named "[version]_[description].sql" and applied in version
+// order. Once released a migration must never change, add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const schemaVersionTable = "CREATE TABLE IF NOT EXISTS schema_version (" +
+	"version INTEGER PRIMARY KEY," +
+	"name TEXT NOT NULL," +
+	"applied_at TEXT NOT NULL" +
+	");"
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// Migrate applies every migration newer than the schema version of the
+// database. Each migration runs in its own transaction, with the version
+// saved on schema_version.
+func Migrate() error {
+	_, err := DB.Exec(schemaVersionTable)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = DB.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version;").Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= current {
+			continue
+		}
+
+		log.Printf("applying migration %04d %s", migration.version, migration.name)
+		err = apply(migration)
+		if err != nil {
+			return fmt.Errorf("migration %04d %s: %w", migration.version, migration.name, err)
+		}
+	}
+
+	return nil
+}
+
+func apply(migration migration) error {
+	tx, err := DB.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(migration.sql)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?);",
+		migration.version, migration.name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func loadMigrations() ([]migration, error) {
+	files, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(files))
+	versions := map[int]string{}
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".sql")
+		strVersion, description, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(strVersion)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration name %q", file.Name())
+		}
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("migrations %q and %q have the same version", other, file.Name())
+		}
+		versions[version] = file.Name()
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: description, sql: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
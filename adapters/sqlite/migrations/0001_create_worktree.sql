@@ -0,0 +1,14 @@
+-- Cache of the entries saved on remote
+CREATE TABLE IF NOT EXISTS worktree (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    mime_type TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT '',
+    changed_at TEXT NOT NULL DEFAULT '',
+    is_dir INTEGER NOT NULL DEFAULT 0,
+    parent TEXT NOT NULL DEFAULT '',
+    full_path TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS worktree_full_path ON worktree (full_path);
+CREATE INDEX IF NOT EXISTS worktree_parent ON worktree (parent);
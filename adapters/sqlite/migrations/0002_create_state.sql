@@ -0,0 +1,5 @@
+-- Values kept between runs, like the remote changes cursor
+CREATE TABLE IF NOT EXISTS state (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
@@ -0,0 +1,9 @@
+-- State of each file when it was last uploaded or downloaded
+CREATE TABLE IF NOT EXISTS synced (
+    full_path TEXT PRIMARY KEY,
+    file_id TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time TEXT NOT NULL,
+    remote_changed_at TEXT NOT NULL,
+    synced_at TEXT NOT NULL
+);
@@ -0,0 +1,11 @@
+-- Files changed both locally and on remote, and how they were resolved
+CREATE TABLE IF NOT EXISTS conflicts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    full_path TEXT NOT NULL,
+    policy TEXT NOT NULL,
+    resolution TEXT NOT NULL,
+    conflict_path TEXT NOT NULL,
+    local_changed_at TEXT NOT NULL,
+    remote_changed_at TEXT NOT NULL,
+    detected_at TEXT NOT NULL
+);
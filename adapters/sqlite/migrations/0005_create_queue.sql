@@ -0,0 +1,14 @@
+-- Pending remote operations, kept until they succeed
+CREATE TABLE IF NOT EXISTS queue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    full_path TEXT NOT NULL,
+    remote_id TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_retry_at INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS queue_next_retry_at ON queue (next_retry_at);
+CREATE INDEX IF NOT EXISTS queue_full_path ON queue (full_path);
@@ -0,0 +1,10 @@
+-- Resumable upload sessions, so an interrupted upload continues after a
+-- restart. A session is only valid for the same file content.
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    full_path TEXT PRIMARY KEY,
+    remote_id TEXT NOT NULL DEFAULT '',
+    session_uri TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
@@ -0,0 +1,2 @@
+-- md5 of the content saved on remote, so unchanged files aren't uploaded
+ALTER TABLE worktree ADD COLUMN md5 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Where the file was before, for moves
+ALTER TABLE queue ADD COLUMN from_path TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,40 @@
+-- synced and worktree belong to the remote they were synced with, so
+-- switching remote (or its root) doesn't take every file as removed.
+-- Rows saved before are claimed by the first remote used, see SetScope.
+CREATE TABLE synced_scoped (
+    scope TEXT NOT NULL DEFAULT '',
+    full_path TEXT NOT NULL,
+    file_id TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    mod_time TEXT NOT NULL,
+    remote_changed_at TEXT NOT NULL,
+    synced_at TEXT NOT NULL,
+    PRIMARY KEY (scope, full_path)
+);
+
+INSERT INTO synced_scoped (full_path, file_id, size, mod_time, remote_changed_at, synced_at)
+    SELECT full_path, file_id, size, mod_time, remote_changed_at, synced_at FROM synced;
+DROP TABLE synced;
+ALTER TABLE synced_scoped RENAME TO synced;
+
+CREATE TABLE worktree_scoped (
+    scope TEXT NOT NULL DEFAULT '',
+    id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    mime_type TEXT NOT NULL DEFAULT '',
+    created_at TEXT NOT NULL DEFAULT '',
+    changed_at TEXT NOT NULL DEFAULT '',
+    is_dir INTEGER NOT NULL DEFAULT 0,
+    parent TEXT NOT NULL DEFAULT '',
+    full_path TEXT NOT NULL DEFAULT '',
+    md5 TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (scope, id)
+);
+
+INSERT INTO worktree_scoped (id, name, mime_type, created_at, changed_at, is_dir, parent, full_path, md5)
+    SELECT id, name, mime_type, created_at, changed_at, is_dir, parent, full_path, md5 FROM worktree;
+DROP TABLE worktree;
+ALTER TABLE worktree_scoped RENAME TO worktree;
+
+CREATE INDEX IF NOT EXISTS worktree_full_path ON worktree (scope, full_path);
+CREATE INDEX IF NOT EXISTS worktree_parent ON worktree (scope, parent);
@@ -0,0 +1,7 @@
+-- md5 of local files, reused while their size and mod_time don't change
+CREATE TABLE IF NOT EXISTS local_hashes (
+    full_path TEXT PRIMARY KEY,
+    size INTEGER NOT NULL,
+    mod_time TEXT NOT NULL,
+    md5 TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- size of the content saved on remote, -1 when it was cached before sizes
+-- were saved
+ALTER TABLE worktree ADD COLUMN size INTEGER NOT NULL DEFAULT -1;
@@ -4,13 +4,29 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	. "superpose-sync/adapters"
 	"superpose-sync/adapters/ConfigFile"
 	"superpose-sync/adapters/inotify"
 	"superpose-sync/adapters/sqlite"
 	"superpose-sync/repositories"
-	"superpose-sync/services/GoogleAPI"
-	"superpose-sync/services/SaveGoogleInfo"
+	"superpose-sync/services/Conflicts"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Queue"
+	"superpose-sync/services/Reconcile"
+	"superpose-sync/services/Remote"
+	"superpose-sync/services/RemoteChanges"
+	"superpose-sync/services/SaveRemoteInfo"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+
+	// storage backends register themselves on Remote
+	_ "superpose-sync/services/GoogleAPI"
+	_ "superpose-sync/services/LocalDir"
+	_ "superpose-sync/services/S3"
+	_ "superpose-sync/services/SFTP"
+	_ "superpose-sync/services/WebDAV"
 
 	"github.com/urfave/cli/v2" // https://cli.urfave.org/v2/
 )
@@ -27,18 +43,46 @@ func main() {
 		Usage: "In quantum superposition a molecule can be in two (or more) quantun states before measurement. I do this with your files :D",
 		Action: func(*cli.Context) error {
 			fmt.Println("\033[32mboom! I say!\033[39m")
+			start()
 			return nil
 		},
+		Commands: []*cli.Command{
+			{
+				Name:  "pull",
+				Usage: "download everything from root_folder_id, use it to add a new workstation",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "force",
+						Usage: "overwrite watched dirs even if they aren't empty",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					return pull(ctx.Bool("force"))
+				},
+			},
+			{
+				Name:  "queue",
+				Usage: "list operations waiting to be sent to remote, and why they failed",
+				Action: func(*cli.Context) error {
+					return listQueue()
+				},
+			},
+			{
+				Name:  "conflicts",
+				Usage: "list files changed on more than one workstation and how they were resolved",
+				Action: func(*cli.Context) error {
+					return listConflicts()
+				},
+			},
+		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
-
-	start()
 }
 
-func start() {
+func bootstrap() <-chan struct{} {
 	err := ConfigFile.ParseFile(watchersFile)
 	if err != nil {
 		log.Fatal(err)
@@ -46,74 +90,384 @@ func start() {
 
 	sqlite.Connect()
 
-	Drive = GoogleAPI.NewDrive()
-	SaveGoogleInfo.StartListener()
+	// What was synced with another remote, or root, isn't taken as synced
+	// with this one
+	err = repositories.SetScope(ConfigFile.RemoteScope())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	remote, err = Remote.New(ConfigFile.GetRemote())
+	if err != nil {
+		log.Fatal(err)
+	}
+	listenerDone := SaveRemoteInfo.StartListener()
+
+	err = Remote.MigratePathScheme(remote)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return listenerDone
+}
+
+func start() {
+	bootstrap()
+
+	// Watches are added before reconciling, so changes made meanwhile wait
+	// on the inotify queue instead of being lost
+	createWatchers()
+
+	err := Reconcile.Run(remote)
+	if err != nil {
+		log.Println("Reconcile.Run error: ", err)
+	}
+
+	RemoteChanges.StartPoller(remote, Reconcile.Run)
+	Queue.Start(remote)
+	Reconcile.StartRescanner()
 
 	startWatchers()
 }
 
+func pull(force bool) error {
+	listenerDone := bootstrap()
+
+	err := RemoteChanges.Pull(remote, force)
+
+	// wait for the worktree cache to be fully saved
+	close(Remote.Events)
+	<-listenerDone
+
+	return err
+}
+
+func listConflicts() error {
+	err := ConfigFile.ParseFile(watchersFile)
+	if err != nil {
+		return err
+	}
+
+	sqlite.Connect()
+
+	conflicts, err := Conflicts.List()
+	if err != nil {
+		return err
+	}
+
+	for _, conflict := range conflicts {
+		fmt.Println(conflict.String())
+	}
+	return nil
+}
+
+func listQueue() error {
+	err := ConfigFile.ParseFile(watchersFile)
+	if err != nil {
+		return err
+	}
+
+	sqlite.Connect()
+
+	items, err := Queue.List()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		fmt.Println(item.String())
+	}
+	return nil
+}
+
 var (
 	watcher *WatcherStruct
 )
 
-func startWatchers() {
+func createWatchers() {
 	var err error
 
 	watcher, err = Watcher()
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
+func startWatchers() {
 	watcher.InotifyWatcher.StartWatch(receiveEvents)
 }
 
 type EventPath struct {
-	Name    string
-	Mask    uint32
-	Watcher inotify.WatchingPath
-	DriveID string
+	Name     string
+	Mask     uint32
+	Watcher  inotify.WatchingPath
+	RemoteID string
+	// FromName is where the file was before being moved to Name, empty
+	// when it wasn't moved during the debounce window
+	FromName string
+	// timer fires the sync once the path is quiet, seq tells apart timers
+	// replaced by newer events
+	timer *time.Timer
+	seq   uint64
 }
 
 var (
-	EventPaths = map[string]EventPath{}
-	Drive      = GoogleAPI.GoogleDrive{}
+	EventPaths      = map[string]EventPath{}
+	eventPathsMutex sync.Mutex
+	eventSeq        uint64
+	remote          Remote.Remote
 )
 
 func (eventPath EventPath) Is(needle uint32) bool {
 	return eventPath.Mask&needle == needle
 }
 
+// receiveEvents coalesces the events of each path until it's quiet for
+// the debounce window, then syncs its final state.
 func receiveEvents(event inotify.FileEvent) {
-	var eventPath EventPath
+	// Events were lost, the watch root is compared with the synced cache
+	if event.Is(inotify.InQOverflow) {
+		Reconcile.MarkDirty(event.Name)
+		return
+	}
+
+	// What an ignore file ignored before may have to be sent now
+	if filepath.Base(event.Name) == ConfigFile.IgnoreFileName {
+		if root, ok := ConfigFile.WatcherRoot(event.Name); ok {
+			Reconcile.MarkDirty(root)
+		}
+	}
+
+	// Changes written by superpose itself (e.g. remote downloads)
+	if EchoGuard.Suppressed(event.Name) {
+		return
+	}
+
+	eventPathsMutex.Lock()
+	defer eventPathsMutex.Unlock()
+
+	// Dirs are moved right away, so the pending events of their content
+	// find the worktree cache already at the new paths
+	if event.Is(inotify.InMovedTo) && event.Is(inotify.InIsDir) {
+		if event.FromName != "" {
+			moveEventPaths(event.FromName, event.Name)
+		}
+		syncMove(event.FromName, event.Name)
+		return
+	}
 
 	eventPath, ok := EventPaths[event.Name]
 	if !ok {
-		id, err := repositories.GetIdByPath(event.Name)
-		if err != nil {
-			log.Println("repositories.GetIdByPath error: ", err)
+		eventPath = EventPath{Name: event.Name}
+	}
+
+	if event.Is(inotify.InMovedTo) && event.FromName != "" {
+		// The pending events of the old path come along, and so does
+		// where it was when the window started
+		from, ok := EventPaths[event.FromName]
+		delete(EventPaths, event.FromName)
+		if ok {
+			from.timer.Stop()
+			eventPath.Mask |= from.Mask
 		}
-		eventPath = EventPath{
-			Name:    event.Name,
-			DriveID: id,
+
+		fromName := event.FromName
+		if ok && from.FromName != "" {
+			fromName = from.FromName
 		}
+
+		// Replaced before its own move was synced, the remote entry is
+		// still at its old path
+		if eventPath.FromName != "" && eventPath.FromName != fromName {
+			deleteRemote(eventPath.FromName)
+		}
+		eventPath.FromName = fromName
+		if eventPath.FromName == eventPath.Name {
+			eventPath.FromName = ""
+		}
+	}
+
+	eventPath.Mask |= event.Mask
+	debounce(eventPath)
+}
+
+// debounce (re)starts the window of eventPath. eventPathsMutex must be
+// held.
+func debounce(eventPath EventPath) {
+	if eventPath.timer != nil {
+		eventPath.timer.Stop()
 	}
 
-	eventPath.Mask += event.Mask
-	EventPaths[event.Name] = eventPath
+	eventSeq++
+	seq := eventSeq
+	eventPath.seq = seq
+	eventPath.timer = time.AfterFunc(ConfigFile.GetDebounce(), func() {
+		flushEventPath(eventPath.Name, seq)
+	})
+	EventPaths[eventPath.Name] = eventPath
+}
+
+// moveEventPaths rekeys the pending events below fromName to newName.
+// eventPathsMutex must be held.
+func moveEventPaths(fromName string, newName string) {
+	for name, eventPath := range EventPaths {
+		newPath, ok := inotify.MovedPath(name, fromName, newName)
+		if !ok {
+			continue
+		}
 
-	if event.Is(inotify.InCloseWrite) || event.Is(inotify.InDelete) || event.Is(inotify.InDeleteSelf) || event.Is(inotify.InMove) {
-		delete(EventPaths, eventPath.Name)
-		syncLocalToRemote(eventPath)
+		delete(EventPaths, name)
+		eventPath.Name = newPath
+		if movedFrom, ok := inotify.MovedPath(eventPath.FromName, fromName, newName); ok {
+			eventPath.FromName = movedFrom
+		}
+		debounce(eventPath)
 	}
 }
 
+// flushEventPath syncs the path once its window expired, unless newer
+// events restarted it.
+func flushEventPath(name string, seq uint64) {
+	eventPathsMutex.Lock()
+	eventPath, ok := EventPaths[name]
+	if !ok || eventPath.seq != seq {
+		eventPathsMutex.Unlock()
+		return
+	}
+	delete(EventPaths, name)
+	eventPathsMutex.Unlock()
+
+	syncLocalToRemote(eventPath)
+}
+
+// syncLocalToRemote queues the single operation that takes the remote to
+// the final state of the path: what was removed is deleted, unless it was
+// created during the window, what was created, written or moved is sent,
+// and only the metadata of what had its attributes changed.
 func syncLocalToRemote(eventPath EventPath) {
-	if eventPath.Is(inotify.InDelete) || eventPath.Is(inotify.InMovedFrom) {
-		Drive.Remove(eventPath.DriveID)
+	info, err := os.Lstat(eventPath.Name)
+	if os.IsNotExist(err) {
+		// Removed after being moved, the remote entry is still at the old
+		// path
+		path := eventPath.Name
+		if eventPath.FromName != "" {
+			path = eventPath.FromName
+		}
+
+		// Removed with its dir, which is deleted with everything inside
+		parent := filepath.Dir(path)
+		if _, rel, ok := ConfigFile.WatcherPath(parent); ok && rel != "." {
+			parentId, _ := repositories.GetIdByPath(parent)
+			if _, err := os.Lstat(parent); os.IsNotExist(err) && parentId != "" {
+				return
+			}
+		}
+
+		deleteRemote(path)
+		return
+	}
+
+	if eventPath.FromName != "" {
+		syncMove(eventPath.FromName, eventPath.Name)
 		return
 	}
 
-	if eventPath.Is(inotify.InCloseWrite) {
-		Drive.Send(eventPath.Name)
+	// Ignored files, like editor swap files, are never sent
+	if isIgnored, err := ConfigFile.PathInIgnore(eventPath.Name); err != nil || isIgnored {
+		if err != nil {
+			log.Println("ConfigFile.PathInIgnore error: ", err)
+		}
+		return
+	}
+
+	// Dirs are created with what was put inside them before being watched
+	if eventPath.Is(inotify.InCreate | inotify.InIsDir) {
+		err = Queue.PushTree(eventPath.Name)
+		if err != nil {
+			log.Println("Queue.PushTree error: ", err)
+		}
+		return
+	}
+
+	// Symlinks are created without being written, and linked dirs are
+	// sent with their content when they are followed
+	if err == nil && utils.IsSymlink(info) && (eventPath.Is(inotify.InCreate) || eventPath.Is(inotify.InMovedTo)) {
+		err = Queue.PushTree(eventPath.Name)
+		if err != nil {
+			log.Println("Queue.PushTree error: ", err)
+		}
+		return
+	}
+
+	action := ""
+	if eventPath.Is(inotify.InCloseWrite) || eventPath.Is(inotify.InMovedTo) {
+		action = Queue.Upload
+	} else if eventPath.Is(inotify.InAttrib) && !eventPath.Is(inotify.InIsDir) {
+		// chmod, touch... the content is the same
+		action = Queue.Metadata
+	}
+	if action == "" {
+		return
+	}
+
+	eventPath.RemoteID, _ = repositories.GetIdByPath(eventPath.Name)
+	err = Queue.Push(action, eventPath.Name, eventPath.RemoteID)
+	if err != nil {
+		log.Println("Queue.Push error: ", err)
+	}
+}
+
+// deleteRemote queues the delete of the remote entry of path, if it was
+// ever synced.
+func deleteRemote(path string) {
+	remoteId, err := repositories.GetIdByPath(path)
+	if err != nil || remoteId == "" {
+		return
+	}
+
+	err = Queue.Push(Queue.Delete, path, remoteId)
+	if err != nil {
+		log.Println("Queue.Push error: ", err)
+	}
+}
+
+// syncMove moves the remote entry of a file or dir moved locally, so it
+// keeps its history. What comes from outside the watched dirs, or was
+// never synced, is uploaded, and what is moved to an ignored path is
+// deleted.
+func syncMove(fromName string, name string) {
+	remoteId := ""
+	if fromName != "" {
+		remoteId, _ = repositories.GetIdByPath(fromName)
+	}
+
+	isIgnored, err := ConfigFile.PathInIgnore(name)
+	if err != nil {
+		log.Println("ConfigFile.PathInIgnore error: ", err)
+		return
+	}
+
+	if isIgnored {
+		if remoteId != "" {
+			err = Queue.Push(Queue.Delete, fromName, remoteId)
+		}
+	} else if remoteId == "" {
+		err = Queue.PushTree(name)
+	} else if targetId, _ := repositories.GetIdByPath(name); targetId != "" && targetId != remoteId {
+		// The move replaced a synced file, e.g. an editor saving through a
+		// temp file, so the replaced entry is updated and keeps its history
+		err = Queue.Push(Queue.Delete, fromName, remoteId)
+		if err == nil {
+			err = Queue.PushTree(name)
+		}
+	} else {
+		err = repositories.MoveTree(fromName, name)
+		if err == nil {
+			err = Queue.PushMove(fromName, name, remoteId)
+		}
+	}
+
+	if err != nil {
+		log.Println("Queue.Push error: ", err)
 	}
 }
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"log"
+	"superpose-sync/adapters/sqlite"
+)
+
+// Conflict is a file changed both locally and on remote since the last sync.
+type Conflict struct {
+	ID              int64  `json:"id"`
+	FullPath        string `json:"full_path"`
+	Policy          string `json:"policy"`
+	Resolution      string `json:"resolution"`
+	ConflictPath    string `json:"conflict_path"`
+	LocalChangedAt  string `json:"local_changed_at"`
+	RemoteChangedAt string `json:"remote_changed_at"`
+	DetectedAt      string `json:"detected_at"`
+}
+
+func (c Conflict) String() string {
+	str := c.DetectedAt + " " + c.FullPath + "\n"
+	str += "  policy: " + c.Policy + ", resolution: " + c.Resolution + "\n"
+	str += "  local changed at: " + c.LocalChangedAt + ", remote changed at: " + c.RemoteChangedAt
+	if c.ConflictPath != "" {
+		str += "\n  conflict copy: " + c.ConflictPath
+	}
+	return str
+}
+
+func SaveConflict(conflict Conflict) error {
+	query := "INSERT INTO conflicts (full_path, policy, resolution, conflict_path, local_changed_at, remote_changed_at, detected_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?);"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("SaveConflict prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(conflict.FullPath, conflict.Policy, conflict.Resolution, conflict.ConflictPath,
+		conflict.LocalChangedAt, conflict.RemoteChangedAt, conflict.DetectedAt)
+	if err != nil {
+		log.Println("SaveConflict execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+func GetConflicts() ([]Conflict, error) {
+	rows, err := sqlite.DB.Query("SELECT id, full_path, policy, resolution, conflict_path, local_changed_at, remote_changed_at, detected_at " +
+		"FROM conflicts ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := []Conflict{}
+	for rows.Next() {
+		conflict := Conflict{}
+		err = rows.Scan(&conflict.ID, &conflict.FullPath, &conflict.Policy, &conflict.Resolution, &conflict.ConflictPath,
+			&conflict.LocalChangedAt, &conflict.RemoteChangedAt, &conflict.DetectedAt)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, rows.Err()
+}
@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"log"
+	"os"
+	"superpose-sync/adapters/sqlite"
+)
+
+// GetLocalHash returns the md5 saved for fullPath, when its size and
+// mtime are still the ones of info.
+func GetLocalHash(fullPath string, info os.FileInfo) (string, bool) {
+	var md5 string
+	query := "SELECT md5 FROM local_hashes WHERE full_path = ? AND size = ? AND mod_time = ?;"
+	err := sqlite.DB.QueryRow(query, fullPath, info.Size(), FormatModTime(info.ModTime())).Scan(&md5)
+	if err != nil {
+		return "", false
+	}
+	return md5, true
+}
+
+func SaveLocalHash(fullPath string, info os.FileInfo, md5 string) error {
+	query := "INSERT INTO local_hashes (full_path, size, mod_time, md5) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT(full_path) DO UPDATE SET size = excluded.size, mod_time = excluded.mod_time, md5 = excluded.md5;"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("SaveLocalHash prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(fullPath, info.Size(), FormatModTime(info.ModTime()), md5)
+	if err != nil {
+		log.Println("SaveLocalHash execute error: ", err)
+		return err
+	}
+	return nil
+}
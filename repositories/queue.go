@@ -0,0 +1,160 @@
+package repositories
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"superpose-sync/adapters/sqlite"
+	"time"
+)
+
+// QueueItem is a remote operation waiting to be done. Failed items are
+// kept, and retried after NextRetryAt. FromPath is where the file was
+// before, for moves.
+type QueueItem struct {
+	ID          int64  `json:"id"`
+	Action      string `json:"action"`
+	FullPath    string `json:"full_path"`
+	RemoteID    string `json:"remote_id"`
+	FromPath    string `json:"from_path"`
+	Attempts    int    `json:"attempts"`
+	LastError   string `json:"last_error"`
+	NextRetryAt int64  `json:"next_retry_at"`
+	CreatedAt   string `json:"created_at"`
+}
+
+func (item QueueItem) String() string {
+	str := item.CreatedAt + " " + item.Action + " " + item.FullPath
+	if item.FromPath != "" {
+		str += " (from " + item.FromPath + ")"
+	}
+	if item.Attempts > 0 {
+		str += "\n  attempts: " + strconv.Itoa(item.Attempts) + ", next retry at: " + FormatModTime(time.UnixMilli(item.NextRetryAt))
+		str += "\n  last error: " + item.LastError
+	}
+	return str
+}
+
+// Enqueue saves item, replacing what was still pending for the same path
+// since only the last operation reflects the current state of the file.
+// Both happen in one transaction, so a crash never loses the operation.
+func Enqueue(item QueueItem) (int64, error) {
+	tx, err := sqlite.DB.Begin()
+	if err != nil {
+		log.Println("Enqueue begin error: ", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM queue WHERE full_path = ?;", item.FullPath)
+	if err != nil {
+		log.Println("Enqueue execute error: ", err)
+		return 0, err
+	}
+
+	query := "INSERT INTO queue (action, full_path, remote_id, from_path, attempts, last_error, next_retry_at, created_at) " +
+		"VALUES (?, ?, ?, ?, 0, '', 0, ?);"
+	result, err := tx.Exec(query, item.Action, item.FullPath, item.RemoteID, item.FromPath, FormatModTime(time.Now()))
+	if err != nil {
+		log.Println("Enqueue execute error: ", err)
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("Enqueue commit error: ", err)
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetDueQueueItems returns up to limit items ready to run at now, oldest first.
+func GetDueQueueItems(now time.Time, limit int) ([]QueueItem, error) {
+	rows, err := sqlite.DB.Query("SELECT "+queueColumns+" "+
+		"FROM queue WHERE next_retry_at <= ? ORDER BY id LIMIT ?;", now.UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []QueueItem{}
+	for rows.Next() {
+		item, err := hidrateQueueItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// GetQueueItemByPath returns the item pending for fullPath, or sql.ErrNoRows.
+func GetQueueItemByPath(fullPath string) (QueueItem, error) {
+	row := sqlite.DB.QueryRow("SELECT "+queueColumns+" FROM queue WHERE full_path = ? ORDER BY id DESC LIMIT 1;", fullPath)
+	return hidrateQueueItem(row)
+}
+
+const queueColumns = "id, action, full_path, remote_id, from_path, attempts, last_error, next_retry_at, created_at"
+
+func hidrateQueueItem(row interface{ Scan(dest ...any) error }) (QueueItem, error) {
+	item := QueueItem{}
+	err := row.Scan(&item.ID, &item.Action, &item.FullPath, &item.RemoteID, &item.FromPath, &item.Attempts, &item.LastError, &item.NextRetryAt, &item.CreatedAt)
+	return item, err
+}
+
+// NextQueueRetry returns when the next failed item is due after now, or
+// zero when none is. Items already due, running or waiting for a busy
+// path, aren't considered: they are picked up when a worker is released.
+func NextQueueRetry(now time.Time) (time.Time, error) {
+	var nextRetryAt sql.NullInt64
+	err := sqlite.DB.QueryRow("SELECT MIN(next_retry_at) FROM queue WHERE next_retry_at > ?;", now.UnixMilli()).Scan(&nextRetryAt)
+	if err != nil || !nextRetryAt.Valid {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(nextRetryAt.Int64), nil
+}
+
+// DeleteQueueItem removes a done item. Items replaced while running are
+// already gone, so nothing is removed.
+func DeleteQueueItem(id int64) error {
+	stmt, err := sqlite.DB.Prepare("DELETE FROM queue WHERE id = ?;")
+	if err != nil {
+		log.Println("DeleteQueueItem prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(id)
+	if err != nil {
+		log.Println("DeleteQueueItem execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// FailQueueItem saves the error of an attempt and when it must be retried.
+func FailQueueItem(id int64, lastError string, nextRetryAt time.Time) error {
+	query := "UPDATE queue SET attempts = attempts + 1, last_error = ?, next_retry_at = ? WHERE id = ?;"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("FailQueueItem prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(lastError, nextRetryAt.UnixMilli(), id)
+	if err != nil {
+		log.Println("FailQueueItem execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// GetQueue returns every pending item, oldest first.
+func GetQueue() ([]QueueItem, error) {
+	return GetDueQueueItems(time.UnixMilli(1<<62), -1)
+}
@@ -0,0 +1,33 @@
+package repositories
+
+import (
+	"log"
+	"superpose-sync/adapters/sqlite"
+)
+
+// scope is the remote synced and worktree rows belong to, see
+// ConfigFile.RemoteScope
+var scope string
+
+// SetScope makes synced and worktree use the rows of scope. Rows saved
+// before they were scoped are claimed by the first scope used.
+func SetScope(newScope string) error {
+	scope = newScope
+
+	for _, table := range []string{"synced", "worktree"} {
+		query := "UPDATE " + table + " SET scope = ? WHERE scope = '' " +
+			"AND NOT EXISTS (SELECT 1 FROM " + table + " WHERE scope = ?);"
+		stmt, err := sqlite.DB.Prepare(query)
+		if err != nil {
+			log.Println("SetScope prepare error: ", err)
+			return err
+		}
+
+		_, err = stmt.Exec(scope, scope)
+		if err != nil {
+			log.Println("SetScope execute error: ", err)
+			return err
+		}
+	}
+	return nil
+}
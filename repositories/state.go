@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"superpose-sync/adapters/sqlite"
+)
+
+// GetState returns the value saved for key, or an empty string if there is none.
+func GetState(key string) (string, error) {
+	var value string
+	err := sqlite.DB.QueryRow("SELECT value FROM state WHERE key = ?;", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+func SetState(key string, value string) error {
+	query := "INSERT INTO state (key, value) VALUES (?, ?) " +
+		"ON CONFLICT(key) DO UPDATE SET value = excluded.value;"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("SetState prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(key, value)
+	if err != nil {
+		log.Println("SetState execute error: ", err)
+		return err
+	}
+	return nil
+}
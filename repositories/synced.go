@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"log"
+	"os"
+	"superpose-sync/adapters/sqlite"
+	"time"
+	"unicode/utf8"
+)
+
+// Synced is the state of a file when it was last uploaded or downloaded,
+// used to find out what changed while superpose was not running.
+type Synced struct {
+	FullPath        string `json:"full_path"`
+	FileID          string `json:"file_id"`
+	Size            int64  `json:"size"`
+	ModTime         string `json:"mod_time"`
+	RemoteChangedAt string `json:"remote_changed_at"`
+	SyncedAt        string `json:"synced_at"`
+}
+
+// FormatModTime is the format used to save and compare local mtimes.
+func FormatModTime(modTime time.Time) string {
+	return modTime.UTC().Format(time.RFC3339Nano)
+}
+
+func SaveSynced(fullPath string, info os.FileInfo, fileId string, remoteChangedAt string) error {
+	query := "INSERT INTO synced (scope, full_path, file_id, size, mod_time, remote_changed_at, synced_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?)" +
+		"ON CONFLICT(scope, full_path) DO UPDATE SET " +
+		"file_id = excluded.file_id," +
+		"size = excluded.size," +
+		"mod_time = excluded.mod_time," +
+		"remote_changed_at = excluded.remote_changed_at," +
+		"synced_at = excluded.synced_at;"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("SaveSynced prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(scope, fullPath, fileId, info.Size(), FormatModTime(info.ModTime()), remoteChangedAt, FormatModTime(time.Now()))
+	if err != nil {
+		log.Println("SaveSynced execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteSynced removes fullPath and everything below it.
+func DeleteSynced(fullPath string) error {
+	query := "DELETE FROM synced WHERE scope = ? AND (full_path = ? OR full_path LIKE ? ESCAPE '\\')"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("DeleteSynced prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(scope, fullPath, escapeLike(fullPath)+"/%")
+	if err != nil {
+		log.Println("DeleteSynced execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// MoveSynced rewrites the path of fullPath and everything below it, moved
+// to newPath, replacing what was synced at newPath.
+func MoveSynced(fullPath string, newPath string) error {
+	err := DeleteSynced(newPath)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE synced SET full_path = ? || substr(full_path, ?) WHERE scope = ? AND (full_path = ? OR full_path LIKE ? ESCAPE '\\')"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("MoveSynced prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(newPath, utf8.RuneCountInString(fullPath)+1, scope, fullPath, escapeLike(fullPath)+"/%")
+	if err != nil {
+		log.Println("MoveSynced execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// SetSyncedRemote updates the remote side of what is synced at fullPath,
+// when the remote entry changed without a new content, e.g. it was moved.
+func SetSyncedRemote(fullPath string, fileId string, remoteChangedAt string) error {
+	stmt, err := sqlite.DB.Prepare("UPDATE synced SET file_id = ?, remote_changed_at = ? WHERE scope = ? AND full_path = ?;")
+	if err != nil {
+		log.Println("SetSyncedRemote prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(fileId, remoteChangedAt, scope, fullPath)
+	if err != nil {
+		log.Println("SetSyncedRemote execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+func GetSynced(fullPath string) (Synced, error) {
+	synced := Synced{}
+	query := "SELECT full_path, file_id, size, mod_time, remote_changed_at, synced_at FROM synced WHERE scope = ? AND full_path = ?;"
+	err := sqlite.DB.QueryRow(query, scope, fullPath).Scan(
+		&synced.FullPath, &synced.FileID, &synced.Size, &synced.ModTime, &synced.RemoteChangedAt, &synced.SyncedAt,
+	)
+	return synced, err
+}
+
+// LocalChanged reports whether info differs from the synced state.
+func (synced Synced) LocalChanged(info os.FileInfo) bool {
+	return info.Size() != synced.Size || FormatModTime(info.ModTime()) != synced.ModTime
+}
+
+// GetAllSynced returns every synced file, indexed by full_path.
+func GetAllSynced() (map[string]Synced, error) {
+	rows, err := sqlite.DB.Query("SELECT full_path, file_id, size, mod_time, remote_changed_at, synced_at FROM synced WHERE scope = ?;", scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	syncedFiles := map[string]Synced{}
+	for rows.Next() {
+		synced := Synced{}
+		err = rows.Scan(&synced.FullPath, &synced.FileID, &synced.Size, &synced.ModTime, &synced.RemoteChangedAt, &synced.SyncedAt)
+		if err != nil {
+			return nil, err
+		}
+		syncedFiles[synced.FullPath] = synced
+	}
+
+	return syncedFiles, rows.Err()
+}
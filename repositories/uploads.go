@@ -0,0 +1,70 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"superpose-sync/adapters/sqlite"
+	"time"
+)
+
+// UploadSession is a resumable upload started for FullPath. RemoteID is
+// empty when the upload creates a new file.
+type UploadSession struct {
+	FullPath   string
+	RemoteID   string
+	SessionURI string
+	Size       int64
+	ModTime    string
+	CreatedAt  time.Time
+}
+
+// GetUploadSession returns the session saved for fullPath, or sql.ErrNoRows.
+func GetUploadSession(fullPath string) (UploadSession, error) {
+	session := UploadSession{}
+	var createdAt string
+	err := sqlite.DB.QueryRow("SELECT full_path, remote_id, session_uri, size, mod_time, created_at FROM upload_sessions WHERE full_path = ?;", fullPath).
+		Scan(&session.FullPath, &session.RemoteID, &session.SessionURI, &session.Size, &session.ModTime, &createdAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println("GetUploadSession error: ", err)
+		}
+		return session, err
+	}
+
+	session.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	return session, nil
+}
+
+func SaveUploadSession(session UploadSession) error {
+	query := "INSERT INTO upload_sessions (full_path, remote_id, session_uri, size, mod_time, created_at) VALUES (?, ?, ?, ?, ?, ?) " +
+		"ON CONFLICT(full_path) DO UPDATE SET remote_id = excluded.remote_id, session_uri = excluded.session_uri, " +
+		"size = excluded.size, mod_time = excluded.mod_time, created_at = excluded.created_at;"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("SaveUploadSession prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(session.FullPath, session.RemoteID, session.SessionURI, session.Size, session.ModTime, FormatModTime(session.CreatedAt))
+	if err != nil {
+		log.Println("SaveUploadSession execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+func DeleteUploadSession(fullPath string) error {
+	stmt, err := sqlite.DB.Prepare("DELETE FROM upload_sessions WHERE full_path = ?;")
+	if err != nil {
+		log.Println("DeleteUploadSession prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(fullPath)
+	if err != nil {
+		log.Println("DeleteUploadSession execute error: ", err)
+		return err
+	}
+	return nil
+}
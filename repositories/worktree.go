@@ -3,8 +3,11 @@ package repositories
 import (
 	"database/sql"
 	"log"
+	"strconv"
+	"strings"
 	"superpose-sync/adapters/sqlite"
 	"superpose-sync/utils"
+	"unicode/utf8"
 )
 
 //var workTreeRepository_CTE = `with cte as (
@@ -33,6 +36,8 @@ type Path struct {
 	IsDir     int    `json:"is_dir"`
 	ParentID  string `json:"parent"`
 	FullPath  string `json:"full_path"`
+	Md5       string `json:"md5"`
+	Size      int64  `json:"size"`
 }
 
 func (p Path) String() string {
@@ -49,20 +54,22 @@ func (p Path) String() string {
 	str += "  CreatedAt: " + p.CreatedAt + ",\n"
 	str += "  IsDir: " + isDir + ",\n"
 	str += "  ParentID: " + p.ParentID + ",\n"
-	str += "  FullPath: " + p.FullPath + "\n"
+	str += "  FullPath: " + p.FullPath + ",\n"
+	str += "  Md5: " + p.Md5 + ",\n"
+	str += "  Size: " + strconv.FormatInt(p.Size, 10) + "\n"
 	str += "}"
 	return str
 }
 
 func Delete(path Path) error {
-	query := "DELETE FROM worktree WHERE id = ?"
+	query := "DELETE FROM worktree WHERE scope = ? AND id = ?"
 	stmt, err := sqlite.DB.Prepare(query)
 	if err != nil {
 		log.Println("Delete prepare error: ", err)
 		return err
 	}
 
-	_, err = stmt.Exec(path.ID)
+	_, err = stmt.Exec(scope, path.ID)
 	if err != nil {
 		log.Println("Delete execute error: ", err)
 		return err
@@ -70,17 +77,75 @@ func Delete(path Path) error {
 	return nil
 }
 
+// DeleteTree removes fullPath and everything below it.
+func DeleteTree(fullPath string) error {
+	query := "DELETE FROM worktree WHERE scope = ? AND (full_path = ? OR full_path LIKE ? ESCAPE '\\')"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("DeleteTree prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(scope, fullPath, escapeLike(fullPath)+"/%")
+	if err != nil {
+		log.Println("DeleteTree execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+// MoveTree rewrites the path of fullPath and everything below it, moved to newPath.
+func MoveTree(fullPath string, newPath string) error {
+	query := "UPDATE worktree SET full_path = ? || substr(full_path, ?) WHERE scope = ? AND (full_path = ? OR full_path LIKE ? ESCAPE '\\')"
+	stmt, err := sqlite.DB.Prepare(query)
+	if err != nil {
+		log.Println("MoveTree prepare error: ", err)
+		return err
+	}
+
+	_, err = stmt.Exec(newPath, utf8.RuneCountInString(fullPath)+1, scope, fullPath, escapeLike(fullPath)+"/%")
+	if err != nil {
+		log.Println("MoveTree execute error: ", err)
+		return err
+	}
+	return nil
+}
+
+func escapeLike(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "%", "\\%")
+	return strings.ReplaceAll(value, "_", "\\_")
+}
+
+// Upsert saves path, replacing the entries cached for the same full_path,
+// e.g. ids left behind by a move.
 func Upsert(path Path) error {
-	query := "INSERT INTO worktree (id, name, mime_type, created_at, changed_at, is_dir, parent, full_path) " +
-		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)" +
-		"ON CONFLICT(id) DO UPDATE SET " +
+	if path.FullPath != "" {
+		stmt, err := sqlite.DB.Prepare("DELETE FROM worktree WHERE scope = ? AND full_path = ? AND id <> ?;")
+		if err != nil {
+			log.Println("Upsert prepare error: ", err)
+			return err
+		}
+
+		_, err = stmt.Exec(scope, path.FullPath, path.ID)
+		if err != nil {
+			log.Println("Upsert execute error: ", err)
+			return err
+		}
+	}
+
+	query := "INSERT INTO worktree (scope, id, name, mime_type, created_at, changed_at, is_dir, parent, full_path, md5, size) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)" +
+		"ON CONFLICT(scope, id) DO UPDATE SET " +
 		"name = excluded.name," +
 		"mime_type = excluded.mime_type," +
 		"created_at = excluded.created_at," +
 		"changed_at = excluded.changed_at," +
 		"is_dir = excluded.is_dir," +
 		"parent = excluded.parent," +
-		"full_path = excluded.full_path;"
+		"full_path = excluded.full_path," +
+		"md5 = excluded.md5," +
+		"size = excluded.size;"
 	stmt, err := sqlite.DB.Prepare(query)
 	//defer stmt.Close()
 	if err != nil {
@@ -88,7 +153,7 @@ func Upsert(path Path) error {
 		return err
 	}
 
-	_, err = stmt.Exec(path.ID, path.Name, path.MimeType, path.CreatedAt, path.ChangedAt, path.IsDir, path.ParentID, path.FullPath)
+	_, err = stmt.Exec(scope, path.ID, path.Name, path.MimeType, path.CreatedAt, path.ChangedAt, path.IsDir, path.ParentID, path.FullPath, path.Md5, path.Size)
 	if err != nil {
 		log.Println("Upsert execute error: ", err)
 		return err
@@ -97,11 +162,7 @@ func Upsert(path Path) error {
 }
 
 func GetIdByPath(path string) (string, error) {
-	path = utils.GetAbsPathLocal(path)
-	query := "select * from worktree where full_path = ?;"
-
-	result := sqlite.DB.QueryRow(query, path)
-	pathResult, err := hidratePath(result)
+	pathResult, err := GetPathByFullPath(path)
 	if err != nil {
 		return "", err
 	}
@@ -109,11 +170,29 @@ func GetIdByPath(path string) (string, error) {
 	return pathResult.ID, err
 }
 
+func GetPathByFullPath(path string) (Path, error) {
+	path = utils.GetAbsPathLocal(path)
+	query := "select " + pathColumns + " from worktree where scope = ? and full_path = ?;"
+
+	result := sqlite.DB.QueryRow(query, scope, path)
+	return hidratePath(result)
+}
+
+func GetPathById(id string) (Path, error) {
+	query := "select " + pathColumns + " from worktree where scope = ? and id = ?;"
+
+	result := sqlite.DB.QueryRow(query, scope, id)
+	return hidratePath(result)
+}
+
+// pathColumns are the columns read by hidratePath, in order
+const pathColumns = "id, name, mime_type, created_at, changed_at, is_dir, parent, full_path, md5, size"
+
 func hidratePath(result *sql.Row) (Path, error) {
 	pathResult := Path{}
 	err := result.Scan(
 		&pathResult.ID, &pathResult.Name, &pathResult.MimeType, &pathResult.CreatedAt, &pathResult.ChangedAt, &pathResult.IsDir,
-		&pathResult.ParentID, &pathResult.FullPath,
+		&pathResult.ParentID, &pathResult.FullPath, &pathResult.Md5, &pathResult.Size,
 	)
 	return pathResult, err
 }
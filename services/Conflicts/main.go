@@ -0,0 +1,176 @@
+package Conflicts
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Remote"
+	"time"
+)
+
+const (
+	UseLocal  = "local"
+	UseRemote = "remote"
+	UseBoth   = "both"
+)
+
+// Upload sends path to remote, unless the remote entry was changed since
+// it was last synced by this machine. In that case the conflict is resolved
+// following conflict_policy.
+func Upload(remote Remote.Remote, path string) error {
+	fileId, err := repositories.GetIdByPath(path)
+	if err != nil || fileId == "" {
+		return send(remote, path)
+	}
+
+	entry, err := remote.Get(fileId)
+	if err != nil {
+		return send(remote, path)
+	}
+
+	synced, err := repositories.GetSynced(path)
+	if err == nil && entry.ModifiedTime == synced.RemoteChangedAt {
+		return send(remote, path)
+	}
+
+	info, err := Remote.LocalInfo(path)
+	if err != nil {
+		return err
+	}
+
+	if Remote.SameContent(path, entry) {
+		return send(remote, path)
+	}
+
+	return Resolve(remote, path, info, entry)
+}
+
+// Download saves entry locally, unless the local copy was changed since it
+// was last synced. In that case the conflict is resolved following
+// conflict_policy.
+func Download(remote Remote.Remote, entry Remote.Entry) error {
+	if entry.IsDir {
+		return Remote.Materialize(remote, entry)
+	}
+
+	path, err := Remote.LocalPath(entry)
+	if err != nil {
+		return Remote.Materialize(remote, entry)
+	}
+
+	info, err := Remote.LocalInfo(path)
+	if err != nil {
+		return Remote.Materialize(remote, entry)
+	}
+
+	synced, err := repositories.GetSynced(path)
+	if (err == nil && !synced.LocalChanged(info)) || Remote.SameContent(path, entry) {
+		return Remote.Materialize(remote, entry)
+	}
+
+	return Resolve(remote, path, info, entry)
+}
+
+// Resolve applies conflict_policy to a file changed both locally and on
+// remote, and saves the conflict so it can be listed later.
+func Resolve(remote Remote.Remote, path string, local os.FileInfo, entry Remote.Entry) error {
+	policy := ConfigFile.GetConflictPolicy()
+	remoteChangedAt, _ := Remote.ChangedAt(entry)
+
+	conflict := repositories.Conflict{
+		FullPath:        path,
+		Policy:          policy,
+		LocalChangedAt:  repositories.FormatModTime(local.ModTime()),
+		RemoteChangedAt: repositories.FormatModTime(remoteChangedAt),
+		DetectedAt:      repositories.FormatModTime(time.Now()),
+	}
+
+	var err error
+	switch policy {
+	case ConfigFile.ConflictPreferLocal:
+		conflict.Resolution = UseLocal
+	case ConfigFile.ConflictPreferRemote:
+		conflict.Resolution = UseRemote
+	case ConfigFile.ConflictNewestWins:
+		conflict.Resolution = UseLocal
+		if remoteChangedAt.After(local.ModTime()) {
+			conflict.Resolution = UseRemote
+		}
+	default:
+		conflict.Resolution = UseBoth
+		conflict.ConflictPath, err = conflictPath(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("conflict on %q: %s, keeping %s", path, policy, conflict.Resolution)
+
+	switch conflict.Resolution {
+	case UseLocal:
+		err = send(remote, path)
+	case UseRemote:
+		err = Remote.Materialize(remote, entry)
+	case UseBoth:
+		err = keepBoth(remote, path, conflict.ConflictPath, entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	return repositories.SaveConflict(conflict)
+}
+
+// keepBoth moves the local file to conflictPath, downloads the remote entry
+// to its place and uploads the local copy as a new file.
+func keepBoth(remote Remote.Remote, path string, conflictPath string, entry Remote.Entry) error {
+	EchoGuard.Expect(path)
+	defer EchoGuard.Done(path)
+	EchoGuard.Expect(conflictPath)
+	defer EchoGuard.Done(conflictPath)
+	err := os.Rename(path, conflictPath)
+	if err != nil {
+		return err
+	}
+
+	err = Remote.Materialize(remote, entry)
+	if err != nil {
+		return err
+	}
+
+	return send(remote, conflictPath)
+}
+
+func send(remote Remote.Remote, path string) error {
+	_, err := Remote.Send(remote, path)
+	return err
+}
+
+// conflictPath returns a free path like "name (conflict hostname 2006-01-02 150405).ext"
+func conflictPath(path string) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	date := time.Now().Format("2006-01-02 150405")
+
+	candidate := fmt.Sprintf("%s (conflict %s %s)%s", base, hostname, date, ext)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s (conflict %s %s %d)%s", base, hostname, date, i, ext)
+	}
+}
+
+func List() ([]repositories.Conflict, error) {
+	return repositories.GetConflicts()
+}
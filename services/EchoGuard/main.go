@@ -0,0 +1,126 @@
+package EchoGuard
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TempPrefix is used for files being written by superpose itself, they
+// are never synced back to the remote.
+const TempPrefix = ".superpose-tmp-"
+
+// window is how long the events of a change made by superpose may take to
+// arrive.
+const window = 5 * time.Second
+
+type expectation struct {
+	until time.Time
+	done  bool
+	// info is how superpose left the path, nil when it was removed
+	info os.FileInfo
+}
+
+var (
+	mutex         sync.Mutex
+	expected      = map[string]expectation{}
+	expectedTrees = map[string]time.Time{}
+)
+
+// Expect marks path as being changed by superpose, its local events are
+// not echoed back to the remote. Done must be called once the change is
+// over.
+func Expect(path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected[path] = expectation{until: time.Now().Add(window)}
+}
+
+// Done saves how superpose left path. Its events are still taken as
+// echoes while it stays that way, once it changes they are real edits.
+func Done(path string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		info = nil
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected[path] = expectation{until: time.Now().Add(window), done: true, info: info}
+}
+
+// ExpectTree marks path and everything below it as removed by superpose,
+// their events are not echoed back while they don't exist.
+func ExpectTree(path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expectedTrees[path] = time.Now().Add(window)
+}
+
+// IsTemp reports whether path is a file being written by superpose.
+func IsTemp(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), TempPrefix)
+}
+
+// Suppressed reports whether the local event for path was caused by superpose.
+func Suppressed(path string) bool {
+	if IsTemp(path) {
+		return true
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	now := time.Now()
+	for expectedPath, expectation := range expected {
+		if now.After(expectation.until) {
+			delete(expected, expectedPath)
+		}
+	}
+	for expectedTree, until := range expectedTrees {
+		if now.After(until) {
+			delete(expectedTrees, expectedTree)
+		}
+	}
+
+	if expectation, ok := expected[path]; ok {
+		if !expectation.done || unchanged(path, expectation.info) {
+			return true
+		}
+
+		// Changed since, this and the next events are real edits
+		delete(expected, path)
+		return false
+	}
+
+	for expectedTree := range expectedTrees {
+		if path == expectedTree || strings.HasPrefix(path, expectedTree+"/") {
+			return unchanged(path, nil)
+		}
+	}
+	return false
+}
+
+// unchanged reports whether path is still as info, or still doesn't exist
+// when info is nil. The size and mtime of dirs change with their content,
+// which has events of its own, they aren't compared.
+func unchanged(path string, info os.FileInfo) bool {
+	current, err := os.Lstat(path)
+	if info == nil || err != nil {
+		return info == nil && os.IsNotExist(err)
+	}
+
+	if current.IsDir() && info.IsDir() {
+		return os.SameFile(current, info) && current.Mode() == info.Mode()
+	}
+
+	return os.SameFile(current, info) &&
+		current.Size() == info.Size() &&
+		current.Mode() == info.Mode() &&
+		current.ModTime().Equal(info.ModTime())
+}
@@ -0,0 +1,161 @@
+package GoogleAPI
+
+import (
+	"log"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"sync"
+
+	drive "google.golang.org/api/drive/v3"
+)
+
+const maxFolderDepth = 64
+
+var (
+	changesFields = "nextPageToken, newStartPageToken, changes(fileId, removed, file(" + filesFields + "))"
+
+	// folderInRoot caches the folders found below root_folder_id. Folders
+	// found outside aren't cached, they may be moved in later.
+	folderInRoot      = map[string]bool{}
+	folderInRootMutex sync.Mutex
+)
+
+func (googleDrive *GoogleDrive) StartCursor() (string, error) {
+	return googleDrive.GetStartPageToken()
+}
+
+// Changes returns what changed below root_folder_id since pageToken.
+func (googleDrive *GoogleDrive) Changes(pageToken string) ([]Remote.Change, string, error) {
+	driveChanges, newPageToken, err := googleDrive.ListChanges(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	changes := []Remote.Change{}
+	for _, driveChange := range driveChanges {
+		change := Remote.Change{
+			ID:      driveChange.FileId,
+			Removed: driveChange.Removed,
+		}
+
+		// Removed files can't be checked, they are only applied when
+		// found on the worktree cache
+		if driveChange.File != nil {
+			if driveChange.File.MimeType == FolderMimeType {
+				forgetFolders()
+			}
+			if !driveChange.Removed && !googleDrive.InRootFolder(driveChange.File) {
+				continue
+			}
+			entry := toEntry(driveChange.File)
+			change.Entry = &entry
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, newPageToken, nil
+}
+
+func (googleDrive *GoogleDrive) GetStartPageToken() (string, error) {
+	var startPageToken *drive.StartPageToken
+	err := retry("GetStartPageToken", func() error {
+		var err error
+		startPageToken, err = googleDrive.service.Changes.GetStartPageToken().Do()
+		return err
+	})
+	if err != nil {
+		log.Println("GetStartPageToken error: ", err)
+		return "", err
+	}
+	return startPageToken.StartPageToken, nil
+}
+
+// ListChanges returns every change made since pageToken, following all pages,
+// and the token to be used on the next call.
+func (googleDrive *GoogleDrive) ListChanges(pageToken string) ([]*drive.Change, string, error) {
+	changes := []*drive.Change{}
+	for pageToken != "" {
+		var changeList *drive.ChangeList
+		err := retry("ListChanges", func() error {
+			var err error
+			changeList, err = googleDrive.service.Changes.List(pageToken).
+				Spaces("drive").
+				IncludeRemoved(true).
+				Fields(changesFields).
+				Do()
+			return err
+		})
+		if err != nil {
+			log.Println("ListChanges error: ", err)
+			return nil, "", err
+		}
+
+		changes = append(changes, changeList.Changes...)
+		if changeList.NewStartPageToken != "" {
+			return changes, changeList.NewStartPageToken, nil
+		}
+		pageToken = changeList.NextPageToken
+	}
+
+	return changes, pageToken, nil
+}
+
+// InRootFolder reports whether file lives somewhere below root_folder_id.
+func (googleDrive *GoogleDrive) InRootFolder(file *drive.File) bool {
+	for _, parentId := range file.Parents {
+		if googleDrive.folderInRoot(parentId, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (googleDrive *GoogleDrive) folderInRoot(id string, depth int) bool {
+	if id == ConfigFile.Configs.GoogleDrive.RootFolderId {
+		return true
+	}
+
+	if _, err := repositories.GetPathById(id); err == nil {
+		return true
+	}
+
+	folderInRootMutex.Lock()
+	inRoot := folderInRoot[id]
+	folderInRootMutex.Unlock()
+	if inRoot {
+		return true
+	}
+
+	if depth > maxFolderDepth {
+		return false
+	}
+
+	folder, err := retryFile("folderInRoot", func() (*drive.File, error) {
+		return googleDrive.service.Files.Get(id).Fields("id, parents").Do()
+	})
+	if err != nil {
+		log.Println("folderInRoot error: ", err)
+		return false
+	}
+
+	for _, parentId := range folder.Parents {
+		if googleDrive.folderInRoot(parentId, depth+1) {
+			folderInRootMutex.Lock()
+			folderInRoot[id] = true
+			folderInRootMutex.Unlock()
+			return true
+		}
+	}
+
+	return false
+}
+
+// forgetFolders clears the folderInRoot cache, a folder that changed may
+// have been moved out of root_folder_id with everything below it.
+func forgetFolders() {
+	folderInRootMutex.Lock()
+	folderInRoot = map[string]bool{}
+	folderInRootMutex.Unlock()
+}
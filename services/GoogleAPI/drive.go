@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"superpose-sync/adapters/ConfigFile"
 	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
 	"superpose-sync/utils"
+	"sync"
 	"time"
 
 	"google.golang.org/api/googleapi"
@@ -20,88 +23,317 @@ import (
 	drive "google.golang.org/api/drive/v3"
 )
 
+const (
+	RemoteName     = "google_drive"
+	FolderMimeType = "application/vnd.google-apps.folder"
+)
+
 type GoogleDrive struct {
 	service drive.Service
+	// client is the authorized client, used for resumable uploads
+	client *http.Client
 }
 
-type DriveEvent struct {
-	File   drive.File
-	Action string
-}
+var (
+	ErrNotFound                 = Remote.ErrNotFound
+	filesFields googleapi.Field = "id, name, mimeType, parents, createdTime, modifiedTime, size, md5Checksum, trashed, appProperties, properties"
+)
+
+var _ Remote.Remote = (*GoogleDrive)(nil)
 
 var (
-	ErrNotFound        = errors.New("drive: path doesn't exists")
-	ChannelDriveEvents chan DriveEvent
-	filesFields        googleapi.Field = "id, name, mimeType, parents, createdTime, modifiedTime, appProperties, properties"
+	treeLocks utils.KeyedMutex
+	// createdFolders keeps the folders created by CreateTree, which reach
+	// the worktree cache asynchronously
+	createdFolders sync.Map
 )
 
 func init() {
-	log.Println("Initiating GoogleDrive API Service")
-	ChannelDriveEvents = make(chan DriveEvent)
+	Remote.Register(RemoteName, func() (Remote.Remote, error) {
+		log.Println("Initiating GoogleDrive API Service")
+		return NewDrive(), nil
+	})
 }
 
-func NewService() GoogleDrive {
-	service, err := drive.NewService(getContext(), option.WithHTTPClient(getOAuthClient()))
+func NewService() *GoogleDrive {
+	client := getOAuthClient()
+	service, err := drive.NewService(getContext(), option.WithHTTPClient(client))
 	if err != nil {
 		log.Fatalf("Unable to create Drive service: %v", err)
 	}
 
-	googleDrive := GoogleDrive{
+	googleDrive := &GoogleDrive{
 		service: *service,
+		client:  client,
 	}
 	return googleDrive
 }
 
-func (googleDrive *GoogleDrive) Remove(id string) error {
-	log.Println("Remove: ", id)
+func (googleDrive *GoogleDrive) Name() string {
+	return RemoteName
+}
+
+func (googleDrive *GoogleDrive) RootID() string {
+	return ConfigFile.Configs.GoogleDrive.RootFolderId
+}
+
+func (googleDrive *GoogleDrive) Stat(localPath string) (Remote.Entry, error) {
+	cached, err := repositories.GetPathByFullPath(localPath)
+	if err == nil {
+		return entryFromCache(cached), nil
+	}
+
+	_, file, err := googleDrive.GetIdByPath(localPath, "")
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	return toEntry(file), nil
+}
+
+func (googleDrive *GoogleDrive) Get(id string) (Remote.Entry, error) {
+	file, err := googleDrive.GetFile(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	return toEntry(file), nil
+}
+
+func (googleDrive *GoogleDrive) List(id string) ([]Remote.Entry, error) {
+	fileList, err := googleDrive.GetList("'" + escapeQuery(id) + "' in parents")
+	if errors.Is(err, ErrNotFound) {
+		return []Remote.Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Remote.Entry, 0, len(fileList.Files))
+	for _, file := range fileList.Files {
+		entries = append(entries, toEntry(file))
+	}
+	return entries, nil
+}
+
+func (googleDrive *GoogleDrive) Delete(entry Remote.Entry) error {
+	log.Println("Remove: ", entry.ID)
 	file := &drive.File{
-		Id: id,
+		Id: entry.ID,
 	}
-	log.Println("drive.File criado")
-	_, err := Do(file, googleDrive.service.Files.Delete(id).Do())
-	// err := googleDrive.service.Files.Delete(id).Do()
+	err := retry("Delete", func() error {
+		return googleDrive.service.Files.Delete(entry.ID).Do()
+	})
+	Do(file, err)
+	createdFolders.Range(func(path, id any) bool {
+		if id == entry.ID {
+			createdFolders.Delete(path)
+		}
+		return true
+	})
 	log.Println("removido: ", err)
 	return err
 }
 
-func (googleDrive *GoogleDrive) Send(filename string) error {
-	info, err := os.Stat(filename)
+func (googleDrive *GoogleDrive) Upload(localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return err
+		return Remote.Entry{}, err
 	}
 
-	goFile, err := os.Open(filename)
+	if info.IsDir() {
+		return googleDrive.Mkdir(localPath)
+	}
+
+	parentId, err := googleDrive.CreateTree(filepath.Dir(localPath), nil)
 	if err != nil {
-		log.Printf("error opening %q: %v", filename, err)
-		return err
+		return Remote.Entry{}, err
 	}
 
-	parentId := googleDrive.CreateTree(filename, info)
+	log.Printf("\u001B[32m[%s] filename: %s | parentId: %s | FileInfo.Name(): %s | FileInfo.Mode(): %s | FileInfo.Mode().Perm(): %s\u001B[39m\n",
+		utils.GetFunctionName(), localPath, parentId, info.Name(), info.Mode(), info.Mode().Perm())
+
+	if isResumable(info) {
+		file, err := generateDriveFile(info.Name(), parentId, localPath)
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+		driveFile, err := Do(googleDrive.uploadResumable(localPath, info, "", file))
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+		return toEntry(driveFile), nil
+	}
 
-	fileId, driveFile, err := googleDrive.FileExists(filename)
+	goFile, err := Remote.OpenLocal(localPath)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
 	}
-	if fileId != "" {
-		driveFile, err = Do(googleDrive.Update(fileId, driveFile).Media(goFile).Fields(filesFields).Do())
+	defer goFile.Close()
+
+	driveFile, err := retryFile("Upload", func() (*drive.File, error) {
+		if _, err := goFile.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		call, err := googleDrive.CreateFile(info.Name(), parentId, localPath)
 		if err != nil {
-			log.Printf("Got drive.File, err: %#v, %v", driveFile, err)
-			return err
+			return nil, err
 		}
-		return nil
+		return call.Media(goFile).Do()
+	})
+	if err != nil {
+		log.Printf("Got drive.File, err: %#v, %v", driveFile, err)
+		return Remote.Entry{}, err
 	}
 
-	log.Printf("\u001B[32m[%s] filename: %s | parentId: %s | FileInfo.Name(): %s | FileInfo.Mode(): %s | FileInfo.Mode().Perm(): %s\u001B[39m\n",
-		utils.GetFunctionName(), filename, parentId, info.Name(), info.Mode(), info.Mode().Perm())
+	return toEntry(driveFile), nil
+}
 
-	driveFile, err = googleDrive.CreateFile(info.Name(), parentId, filename).Media(goFile).Do()
+func (googleDrive *GoogleDrive) Update(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	if isResumable(info) {
+		driveFile, err := Do(googleDrive.uploadResumable(localPath, info, entry.ID, generateDriveFileMetadata(localPath, info)))
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+		return toEntry(driveFile), nil
+	}
+
+	goFile, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
+	}
+	defer goFile.Close()
+
+	driveFile, err := Do(retryFile("Update", func() (*drive.File, error) {
+		if _, err := goFile.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return googleDrive.service.Files.Update(entry.ID, generateDriveFileMetadata(localPath, info)).Media(goFile).Fields(filesFields).Do()
+	}))
 	if err != nil {
 		log.Printf("Got drive.File, err: %#v, %v", driveFile, err)
+		return Remote.Entry{}, err
+	}
+
+	return toEntry(driveFile), nil
+}
+
+func (googleDrive *GoogleDrive) Download(entry Remote.Entry, w io.Writer) error {
+	if strings.HasPrefix(entry.MimeType, "application/vnd.google-apps.") {
+		return Remote.ErrNotDownloadable
+	}
+
+	var response *http.Response
+	err := retry("Download", func() error {
+		var err error
+		response, err = googleDrive.service.Files.Get(entry.ID).Download()
+		return err
+	})
+	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 
-	return nil
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+func (googleDrive *GoogleDrive) Mkdir(localPath string) (Remote.Entry, error) {
+	folderId, err := googleDrive.CreateTree(localPath, nil)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	cached, err := repositories.GetPathById(folderId)
+	if err == nil {
+		return entryFromCache(cached), nil
+	}
+	return googleDrive.Get(folderId)
+}
+
+func (googleDrive *GoogleDrive) Move(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	current, err := googleDrive.GetFile(entry.ID)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	parentId, err := googleDrive.CreateTree(filepath.Dir(localPath), nil)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	driveFile := generateDriveFileMetadata(localPath, info)
+	driveFile.Name = filepath.Base(localPath)
+
+	call := googleDrive.service.Files.Update(entry.ID, driveFile).Fields(filesFields)
+	if len(current.Parents) != 1 || current.Parents[0] != parentId {
+		call = call.AddParents(parentId).RemoveParents(strings.Join(current.Parents, ","))
+	}
+
+	file, err := Do(retryFile("Move", func() (*drive.File, error) {
+		return call.Do()
+	}))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	// Paths saved on properties must follow the content of folders too
+	oldPath := ConfigFile.LocalPathFromProperties(current.AppProperties)
+	if file.MimeType == FolderMimeType && oldPath != "" {
+		err = googleDrive.refreshTree(file.Id, oldPath, localPath)
+	}
+	return toEntry(file), err
+}
+
+// refreshTree rewrites the path properties of everything inside the folder
+// id, moved from oldPath to localPath.
+func (googleDrive *GoogleDrive) refreshTree(id string, oldPath string, localPath string) error {
+	return Remote.WalkTree(googleDrive, id, func(child Remote.Entry) error {
+		rel, err := filepath.Rel(oldPath, ConfigFile.LocalPathFromProperties(child.Properties))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			log.Printf("refreshTree: skipping %q, it has no path below %q", child.Name, oldPath)
+			return nil
+		}
+
+		properties := map[string]string{}
+		for name, value := range child.Properties {
+			properties[name] = value
+		}
+		for name, value := range ConfigFile.PathProperties(filepath.Join(localPath, rel)) {
+			properties[name] = value
+		}
+
+		_, err = googleDrive.SetMetadata(child, properties)
+		return err
+	})
+}
+
+func (googleDrive *GoogleDrive) SetMetadata(entry Remote.Entry, properties map[string]string) (Remote.Entry, error) {
+	driveFile := &drive.File{
+		AppProperties: properties,
+		Properties:    properties,
+	}
+	if changedAt, ok := Remote.ChangedAt(Remote.Entry{Properties: properties}); ok {
+		driveFile.ModifiedTime = changedAt.Format(time.RFC3339)
+	}
+
+	file, err := Do(retryFile("SetMetadata", func() (*drive.File, error) {
+		return googleDrive.service.Files.Update(entry.ID, applyDescription(driveFile)).Fields(filesFields).Do()
+	}))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	return toEntry(file), nil
 }
 
 type DrivePrepared struct {
@@ -117,9 +349,13 @@ func Do(file *drive.File, err error) (*drive.File, error) {
 	if err != nil {
 		log.Printf("Got drive.File, err: %#v, %v", file, err)
 	} else {
-		sendEventMessage(DriveEvent{
-			File:   *file,
-			Action: utils.GetFunctionNameSkip(1),
+		action := Remote.Saved
+		if strings.HasSuffix(utils.GetFunctionNameSkip(1), ".Delete") {
+			action = Remote.Removed
+		}
+		Remote.Notify(Remote.Event{
+			Entry:  toEntry(file),
+			Action: action,
 		})
 	}
 	return file, err
@@ -142,88 +378,134 @@ func (googleDrive *GoogleDrive) FileExists(fullPath string) (string, *drive.File
 	return parentId, file, nil
 }
 
-func (googleDrive *GoogleDrive) CreateTree(fullPath string, info os.FileInfo) string {
+// CreateTree returns the id of the folder of fullPath, creating it and
+// its parents when they aren't on Drive yet. A fullPath removed locally
+// meanwhile returns ErrNotFound.
+func (googleDrive *GoogleDrive) CreateTree(fullPath string, info os.FileInfo) (string, error) {
 	log.Printf("[%s] fullPath: %v\n", utils.GetFunctionName(), fullPath)
 	parentId, _, err := googleDrive.FileExists(fullPath)
 	if err == nil {
-		return parentId
+		return parentId, nil
 	}
 
 	if info == nil {
-		_info, err := os.Stat(fullPath)
+		info, err = os.Stat(fullPath)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
 		if err != nil {
-			log.Fatalln(os.Stderr, err)
+			return "", err
 		}
-		info = _info
 	}
 	path := fullPath
 	if !info.IsDir() {
 		path = filepath.Dir(fullPath)
 	}
-	path = utils.GetAbsPathRemote(path)
-
-	pathList := strings.Split(path, "/")
-
-	parentId = ""
-	actualPathTree := []string{}
+	// The tree is created following the remote form of path, so with the
+	// relative path_scheme every machine shares the same folders
+	remotePath := ConfigFile.RemotePath(utils.GetAbsPathLocal(path))
+	pathList := strings.Split(strings.Trim(remotePath, "/"), "/")
+
+	parentId = ConfigFile.Configs.GoogleDrive.RootFolderId
+	actualPathTree := ""
+	if strings.HasPrefix(remotePath, "/") {
+		actualPathTree = "/"
+	}
 	for _, dir := range pathList {
-		actualPathTree = append(actualPathTree, dir)
-		if dir == utils.REMOTE_PREFIX {
-			parentId = ConfigFile.Configs.GoogleDrive.RootFolderId
-			continue
+		actualPathTree = filepath.Join(actualPathTree, dir)
+		strActualPathTree := ConfigFile.LocalPath(actualPathTree)
+
+		lookupParentId := parentId
+		if ConfigFile.RelativePaths() {
+			lookupParentId = ""
 		}
 
-		strActualPathTree := filepath.Join(actualPathTree...)
+		// Uploads run in parallel, so only one of them may look up and
+		// create each folder
+		unlock := treeLocks.Lock(strActualPathTree)
 		_parentId, err := repositories.GetIdByPath(strActualPathTree)
 		if errors.Is(err, sql.ErrNoRows) {
-			_parentId, _, err = googleDrive.GetIdByPath(strActualPathTree, parentId)
-			if errors.Is(err, ErrNotFound) {
-				folder, errCreate := googleDrive.CreateFile(dir, parentId, strActualPathTree).Do()
-				if errCreate != nil {
-					log.Fatalln(os.Stderr, errCreate)
+			if createdId, ok := createdFolders.Load(strActualPathTree); ok {
+				_parentId = createdId.(string)
+			} else {
+				_parentId, _, err = googleDrive.GetIdByPath(strActualPathTree, lookupParentId)
+				if errors.Is(err, ErrNotFound) {
+					var folder *drive.File
+					folder, err = retryFile("CreateTree", func() (*drive.File, error) {
+						call, err := googleDrive.CreateFile(dir, parentId, strActualPathTree)
+						if err != nil {
+							return nil, err
+						}
+						return call.Do()
+					})
+					if err == nil {
+						_parentId = folder.Id
+						createdFolders.Store(strActualPathTree, folder.Id)
+					}
+				}
+				if err != nil {
+					unlock()
+					return "", err
 				}
-				_parentId = folder.Id
 			}
 		}
+		unlock()
 		parentId = _parentId
 	}
 
-	return parentId
+	return parentId, nil
 }
 
-func (googleDrive *GoogleDrive) Update(fileId string, file *drive.File) *drive.FilesUpdateCall {
-	return googleDrive.service.Files.Update(fileId, file)
+func (googleDrive *GoogleDrive) GetFile(fileId string) (*drive.File, error) {
+	return retryFile("GetFile", func() (*drive.File, error) {
+		return googleDrive.service.Files.Get(fileId).Fields(filesFields).Do()
+	})
 }
 
-func (googleDrive *GoogleDrive) CreateFile(name string, parentId string, path string) DrivePrepared {
-	file := generateDriveFile(name, parentId, path)
-	return DrivePrepared{fileCreateCall: googleDrive.service.Files.Create(file)}
+func (googleDrive *GoogleDrive) CreateFile(name string, parentId string, path string) (DrivePrepared, error) {
+	file, err := generateDriveFile(name, parentId, path)
+	if err != nil {
+		return DrivePrepared{}, err
+	}
+	return DrivePrepared{fileCreateCall: googleDrive.service.Files.Create(file)}, nil
 }
 
-func generateDriveFile(name string, parentId string, path string) *drive.File {
+// generateDriveFile returns the metadata of a new file or folder, or
+// ErrNotFound when path was removed locally meanwhile.
+func generateDriveFile(name string, parentId string, path string) (*drive.File, error) {
 	path = utils.GetAbsPathLocal(path)
-	info, err := os.Stat(path)
+	info, err := Remote.LocalInfo(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
 	if err != nil {
-		log.Fatalf("Unable to Stat path: %#v, %v", path, err)
+		return nil, err
 	}
-	appProperties := generateAppProperties(path, info)
 
-	driveFile := &drive.File{
-		Name:             name,
-		AppProperties:    appProperties,
-		Properties:       appProperties,
-		ModifiedTime:     info.ModTime().Format(time.RFC3339),
-		OriginalFilename: path,
-		Parents:          []string{parentId},
-	}
+	driveFile := generateDriveFileMetadata(path, info)
+	driveFile.Name = name
+	driveFile.OriginalFilename = path
+	driveFile.Parents = []string{parentId}
 
 	if info.IsDir() {
-		driveFile.MimeType = "application/vnd.google-apps.folder"
+		driveFile.MimeType = FolderMimeType
 	}
 
-	driveFile = applyDescription(driveFile)
+	return driveFile, nil
+}
+
+// generateDriveFileMetadata returns only the fields that must be refreshed
+// on every update, so other machines apply the current mode and mtime.
+func generateDriveFileMetadata(path string, info os.FileInfo) *drive.File {
+	appProperties := Remote.Properties(utils.GetAbsPathLocal(path), info)
 
-	return driveFile
+	driveFile := &drive.File{
+		AppProperties: appProperties,
+		Properties:    appProperties,
+		ModifiedTime:  info.ModTime().Format(time.RFC3339),
+	}
+
+	return applyDescription(driveFile)
 }
 
 func applyDescription(f *drive.File) *drive.File {
@@ -236,18 +518,6 @@ func applyDescription(f *drive.File) *drive.File {
 	return f
 }
 
-func generateAppProperties(path string, info os.FileInfo) map[string]string {
-	path = utils.GetAbsPathLocal(path)
-
-	appProperties := map[string]string{
-		"fullPath":  path,
-		"mode":      fmt.Sprintf("%04o", info.Mode().Perm()),
-		"changedAt": info.ModTime().String(),
-	}
-
-	return appProperties
-}
-
 func (googleDrive *GoogleDrive) ListAll() (*drive.FileList, error) {
 	return googleDrive.GetList("")
 }
@@ -258,8 +528,15 @@ func (googleDrive *GoogleDrive) GetList(query string) (*drive.FileList, error) {
 		q += " and " + query
 	}
 
-	var fields = "files(" + filesFields + ")"
-	fileList, err := googleDrive.service.Files.List().Q(q).Fields(fields).Do()
+	var fields = "nextPageToken, files(" + filesFields + ")"
+	fileList := &drive.FileList{}
+	err := retry("GetList", func() error {
+		fileList.Files = nil
+		return googleDrive.service.Files.List().Q(q).Fields(fields).PageSize(1000).Pages(getContext(), func(page *drive.FileList) error {
+			fileList.Files = append(fileList.Files, page.Files...)
+			return nil
+		})
+	})
 	if err != nil {
 		log.Printf("Got Files.List error: %#v, %v", fileList, err)
 		return nil, err
@@ -273,8 +550,8 @@ func (googleDrive *GoogleDrive) GetList(query string) (*drive.FileList, error) {
 }
 
 func (googleDrive *GoogleDrive) GetIdByPath(fullPath string, parentId string) (string, *drive.File, error) {
-	fullPath = utils.GetAbsPathLocal(fullPath)
-	query := "appProperties has { key='fullPath' and value='" + fullPath + "' }"
+	fullPath = ConfigFile.RemotePath(utils.GetAbsPathLocal(fullPath))
+	query := "appProperties has { key='fullPath' and value='" + escapeQuery(fullPath) + "' }"
 	if parentId != "" {
 		query += " and '" + parentId + "' in parents"
 	}
@@ -288,14 +565,49 @@ func (googleDrive *GoogleDrive) GetIdByPath(fullPath string, parentId string) (s
 		return "", nil, ErrNotFound
 	}
 
-	sendEventMessage(DriveEvent{
-		File:   *fileList.Files[0],
-		Action: utils.GetFunctionName(),
+	Remote.Notify(Remote.Event{
+		Entry:  toEntry(fileList.Files[0]),
+		Action: Remote.Saved,
 	})
 
 	return fileList.Files[0].Id, fileList.Files[0], nil
 }
 
-func sendEventMessage(event DriveEvent) {
-	ChannelDriveEvents <- event
+// escapeQuery escapes value to be used between single quotes on Files.List queries
+func escapeQuery(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	return strings.ReplaceAll(value, "'", "\\'")
+}
+
+func toEntry(file *drive.File) Remote.Entry {
+	entry := Remote.Entry{
+		ID:           file.Id,
+		Name:         file.Name,
+		IsDir:        file.MimeType == FolderMimeType,
+		MimeType:     file.MimeType,
+		Size:         file.Size,
+		Md5:          file.Md5Checksum,
+		CreatedTime:  file.CreatedTime,
+		ModifiedTime: file.ModifiedTime,
+		Trashed:      file.Trashed,
+		Properties:   file.AppProperties,
+	}
+	if len(file.Parents) > 0 {
+		entry.ParentID = file.Parents[0]
+	}
+	return entry
+}
+
+func entryFromCache(path repositories.Path) Remote.Entry {
+	return Remote.Entry{
+		ID:           path.ID,
+		Name:         path.Name,
+		ParentID:     path.ParentID,
+		IsDir:        path.IsDir == 1,
+		MimeType:     path.MimeType,
+		CreatedTime:  path.CreatedAt,
+		ModifiedTime: path.ChangedAt,
+		Md5:          path.Md5,
+		Size:         path.Size,
+	}
 }
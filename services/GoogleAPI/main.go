@@ -46,13 +46,13 @@ func getConfig() *oauth2.Config {
 }
 
 func getContext() context.Context {
-	ctx := context.Background()
+	transport := http.DefaultTransport
 	if *debug {
-		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
-			Transport: &utils.LogTransport{http.DefaultTransport},
-		})
+		transport = &utils.LogTransport{RT: transport}
 	}
-	return ctx
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: &rateLimitTransport{RT: transport},
+	})
 }
 
 func newOAuthClient(ctx context.Context, config *oauth2.Config) *http.Client {
@@ -162,6 +162,6 @@ func valueOrFileContents(value string, filename string) string {
 	return strings.TrimSpace(string(slurp))
 }
 
-func NewDrive() GoogleDrive {
+func NewDrive() *GoogleDrive {
 	return NewService()
 }
@@ -0,0 +1,141 @@
+package GoogleAPI
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	maxAttempts     = 8
+	retryBaseDelay  = time.Second
+	retryMaxDelay   = time.Minute
+	maxRetryAfter   = 10 * time.Minute
+	rateLimitReason = "rateLimitExceeded"
+	userLimitReason = "userRateLimitExceeded"
+)
+
+var (
+	limiter     *utils.RateLimiter
+	limiterOnce sync.Once
+)
+
+// rateLimitTransport waits for the request budget before every request sent
+// to Google, so a burst of events doesn't exhaust the quota.
+type rateLimitTransport struct {
+	RT http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiterOnce.Do(func() {
+		requestsPerSecond := ConfigFile.GetDriveRequestsPerSecond()
+		limiter = utils.NewRateLimiter(requestsPerSecond, int(requestsPerSecond)+1)
+	})
+	limiter.Wait()
+	return t.RT.RoundTrip(req)
+}
+
+// retry runs call again while it fails with a temporary error, like rate
+// limits or Drive being unavailable, up to maxAttempts.
+func retry(name string, call func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !isTemporary(err) || attempt == maxAttempts {
+			return err
+		}
+
+		delay := retryDelay(err, attempt)
+		log.Printf("%s failed on attempt %d, retrying in %s: %v", name, attempt, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+func retryFile(name string, call func() (*drive.File, error)) (*drive.File, error) {
+	var file *drive.File
+	err := retry(name, func() error {
+		var err error
+		file, err = call()
+		return err
+	})
+	return file, err
+}
+
+// isTemporary reports whether err may succeed if tried again later.
+func isTemporary(err error) bool {
+	if errors.Is(err, errSessionExpired) {
+		return true
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests, apiErr.Code >= 500:
+		return true
+	case apiErr.Code == http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if item.Reason == rateLimitReason || item.Reason == userLimitReason {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryDelay returns what Retry-After asks for, or a jittered exponential
+// delay when it's missing.
+func retryDelay(err error, attempt int) time.Duration {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		if delay, ok := parseRetryAfter(apiErr.Header.Get("Retry-After")); ok {
+			return delay
+		}
+	}
+
+	delay := retryBaseDelay
+	for i := 1; i < attempt && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter reads Retry-After as seconds or as an http date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, false
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay, true
+}
@@ -0,0 +1,199 @@
+package GoogleAPI
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"time"
+
+	drive "google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// sessionMaxAge is how long a saved session is resumed, Drive keeps them
+// for a week.
+const sessionMaxAge = 6 * 24 * time.Hour
+
+// errSessionExpired is returned when Drive forgot the upload session, the
+// upload starts again with a new one.
+var errSessionExpired = errors.New("upload session expired")
+
+// isResumable reports whether info is big enough to be sent in chunks.
+func isResumable(info os.FileInfo) bool {
+	return info.Size() > ConfigFile.GetDriveChunkSize()
+}
+
+// uploadResumable sends localPath in chunks using a resumable upload
+// session, which is saved so an interrupted upload continues from the
+// last chunk, even after a restart. remoteId is the file being updated,
+// empty to create driveFile.
+func (googleDrive *GoogleDrive) uploadResumable(localPath string, info os.FileInfo, remoteId string, driveFile *drive.File) (*drive.File, error) {
+	goFile, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return nil, err
+	}
+	defer goFile.Close()
+
+	var file *drive.File
+	err = retry("Upload "+localPath, func() error {
+		sessionURI, err := googleDrive.uploadSession(localPath, info, remoteId, driveFile)
+		if err != nil {
+			return err
+		}
+
+		file, err = googleDrive.sendChunks(sessionURI, goFile, localPath, info.Size())
+		if errors.Is(err, errSessionExpired) {
+			repositories.DeleteUploadSession(localPath)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Upload %s: 100%% (%d bytes)", localPath, info.Size())
+	repositories.DeleteUploadSession(localPath)
+	return file, nil
+}
+
+// uploadSession returns the saved session of localPath if it's still valid
+// for the same content, or starts a new one.
+func (googleDrive *GoogleDrive) uploadSession(localPath string, info os.FileInfo, remoteId string, driveFile *drive.File) (string, error) {
+	modTime := repositories.FormatModTime(info.ModTime())
+	session, err := repositories.GetUploadSession(localPath)
+	if err == nil && session.RemoteID == remoteId && session.Size == info.Size() && session.ModTime == modTime &&
+		time.Since(session.CreatedAt) < sessionMaxAge {
+		log.Printf("Upload %s: resuming session", localPath)
+		return session.SessionURI, nil
+	}
+
+	body, err := json.Marshal(driveFile)
+	if err != nil {
+		return "", err
+	}
+
+	method := http.MethodPost
+	uploadURL := googleapi.ResolveRelative(googleDrive.service.BasePath, "/upload/drive/v3/files")
+	if remoteId != "" {
+		method = http.MethodPatch
+		uploadURL += "/" + url.PathEscape(remoteId)
+	}
+	uploadURL += "?uploadType=resumable&fields=" + url.QueryEscape(string(filesFields))
+
+	request, err := http.NewRequest(method, uploadURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("X-Upload-Content-Length", strconv.FormatInt(info.Size(), 10))
+
+	response, err := googleDrive.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	err = googleapi.CheckResponse(response)
+	if err != nil {
+		return "", err
+	}
+
+	sessionURI := response.Header.Get("Location")
+	if sessionURI == "" {
+		return "", errors.New("upload session without location")
+	}
+
+	repositories.SaveUploadSession(repositories.UploadSession{
+		FullPath:   localPath,
+		RemoteID:   remoteId,
+		SessionURI: sessionURI,
+		Size:       info.Size(),
+		ModTime:    modTime,
+		CreatedAt:  time.Now(),
+	})
+	return sessionURI, nil
+}
+
+// sendChunks sends what Drive is still missing of goFile, one chunk per
+// request.
+func (googleDrive *GoogleDrive) sendChunks(sessionURI string, goFile Remote.Content, localPath string, size int64) (*drive.File, error) {
+	request, err := http.NewRequest(http.MethodPut, sessionURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+
+	offset, file, err := googleDrive.sendChunk(request)
+	if err != nil || file != nil {
+		return file, err
+	}
+
+	chunkSize := ConfigFile.GetDriveChunkSize()
+	for {
+		end := offset + chunkSize
+		if end > size {
+			end = size
+		}
+
+		request, err := http.NewRequest(http.MethodPut, sessionURI, io.NewSectionReader(goFile, offset, end-offset))
+		if err != nil {
+			return nil, err
+		}
+		request.ContentLength = end - offset
+		request.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size))
+
+		offset, file, err = googleDrive.sendChunk(request)
+		if err != nil || file != nil {
+			return file, err
+		}
+
+		log.Printf("Upload %s: %d%% (%d of %d bytes)", localPath, offset*100/size, offset, size)
+	}
+}
+
+// sendChunk returns the offset of the next chunk, or the file once Drive
+// has all the content.
+func (googleDrive *GoogleDrive) sendChunk(request *http.Request) (int64, *drive.File, error) {
+	response, err := googleDrive.client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		file := &drive.File{}
+		err = json.NewDecoder(response.Body).Decode(file)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, file, nil
+	case http.StatusPermanentRedirect:
+		// Range is "bytes=0-[last byte received]", missing if none was
+		_, received, ok := strings.Cut(response.Header.Get("Range"), "-")
+		if !ok {
+			return 0, nil, nil
+		}
+		last, err := strconv.ParseInt(received, 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		return last + 1, nil, nil
+	case http.StatusNotFound, http.StatusGone:
+		return 0, nil, errSessionExpired
+	}
+
+	return 0, nil, googleapi.CheckResponse(response)
+}
@@ -0,0 +1,121 @@
+package LocalDir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"superpose-sync/services/Remote"
+)
+
+// journalEntry is a line of "[root]/.superpose/journal". Every machine
+// appends what it changes, and cursors are offsets on this file.
+type journalEntry struct {
+	ID      string `json:"id"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+func (localDir *LocalDir) journalPath() string {
+	return filepath.Join(localDir.root, metaDir, "journal")
+}
+
+// journal appends a change of id. Lines are written with a single
+// O_APPEND write, so machines sharing root don't mix their lines.
+func (localDir *LocalDir) journal(id string, removed bool) error {
+	line, err := json.Marshal(journalEntry{ID: id, Removed: removed})
+	if err != nil {
+		return err
+	}
+
+	localDir.mutex.Lock()
+	defer localDir.mutex.Unlock()
+
+	file, err := os.OpenFile(localDir.journalPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (localDir *LocalDir) StartCursor() (string, error) {
+	info, err := os.Stat(localDir.journalPath())
+	if os.IsNotExist(err) {
+		return "0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(info.Size(), 10), nil
+}
+
+// Changes reads the journal from cursor. Only the last change of each id
+// is returned, with the current state of the entry.
+func (localDir *LocalDir) Changes(cursor string) ([]Remote.Change, string, error) {
+	offset, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(localDir.journalPath())
+	if os.IsNotExist(err) {
+		return []Remote.Change{}, cursor, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A line being written by another machine is read on the next poll
+	end := bytes.LastIndexByte(content, '\n') + 1
+	lines := bytes.Split(content[:end], []byte{'\n'})
+
+	ids := []string{}
+	last := map[string]int{}
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var change journalEntry
+		if json.Unmarshal(line, &change) != nil {
+			continue
+		}
+		last[change.ID] = len(ids)
+		ids = append(ids, change.ID)
+	}
+
+	changes := []Remote.Change{}
+	for i, id := range ids {
+		if last[id] != i {
+			continue
+		}
+
+		entry, err := localDir.Get(id)
+		if errors.Is(err, Remote.ErrNotFound) {
+			changes = append(changes, Remote.Change{ID: id, Removed: true})
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, Remote.Change{ID: id, Entry: &entry})
+	}
+
+	return changes, strconv.FormatInt(offset+int64(end), 10), nil
+}
@@ -0,0 +1,430 @@
+package LocalDir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+)
+
+const (
+	RemoteName = "local"
+	// metaDir keeps sidecar metadata and the changes journal, inside root
+	metaDir        = ".superpose"
+	FolderMimeType = "inode/directory"
+)
+
+var ErrNoPath = errors.New("local: path is not configured")
+
+// LocalDir mirrors the watched dirs into another local directory, e.g. a
+// mounted NAS share or an external disk. Entries are identified by their
+// path relative to root, and the metadata saved on Drive appProperties is
+// kept on a JSON sidecar file for each entry.
+type LocalDir struct {
+	root  string
+	mutex sync.Mutex
+}
+
+var _ Remote.Remote = (*LocalDir)(nil)
+
+func init() {
+	Remote.Register(RemoteName, func() (Remote.Remote, error) {
+		return NewLocalDir(ConfigFile.Configs.Local.Path)
+	})
+}
+
+func NewLocalDir(root string) (*LocalDir, error) {
+	if root == "" {
+		return nil, ErrNoPath
+	}
+
+	root, err := utils.GetAbsPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(root, metaDir), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Initiating local remote on %q", root)
+	return &LocalDir{root: filepath.Clean(root)}, nil
+}
+
+func (localDir *LocalDir) Name() string {
+	return RemoteName
+}
+
+func (localDir *LocalDir) RootID() string {
+	return "."
+}
+
+func (localDir *LocalDir) Stat(localPath string) (Remote.Entry, error) {
+	return localDir.Get(Remote.Key(localPath))
+}
+
+func (localDir *LocalDir) Get(id string) (Remote.Entry, error) {
+	absPath, err := localDir.absPath(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return Remote.Entry{}, Remote.ErrNotFound
+	}
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return localDir.toEntry(id, info), nil
+}
+
+func (localDir *LocalDir) List(id string) ([]Remote.Entry, error) {
+	absPath, err := localDir.absPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(absPath)
+	if os.IsNotExist(err) {
+		return []Remote.Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Remote.Entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if (id == "." && name == metaDir) || strings.HasPrefix(name, EchoGuard.TempPrefix) {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, localDir.toEntry(path.Join(id, name), info))
+	}
+	return entries, nil
+}
+
+func (localDir *LocalDir) Upload(localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	if info.IsDir() {
+		return localDir.Mkdir(localPath)
+	}
+
+	_, err = localDir.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return localDir.write(Remote.Key(localPath), localPath, info)
+}
+
+func (localDir *LocalDir) Update(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return localDir.write(entry.ID, localPath, info)
+}
+
+func (localDir *LocalDir) Download(entry Remote.Entry, w io.Writer) error {
+	absPath, err := localDir.absPath(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(absPath)
+	if os.IsNotExist(err) {
+		return Remote.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func (localDir *LocalDir) Mkdir(localPath string) (Remote.Entry, error) {
+	return Remote.MkdirAll(localDir, localPath, localDir.mkdir)
+}
+
+// mkdir creates the dir id, its parent already exists.
+func (localDir *LocalDir) mkdir(id string, localPath string) (Remote.Entry, error) {
+	entry, err := localDir.Get(id)
+	if err == nil {
+		return entry, nil
+	}
+
+	absPath, err := localDir.absPath(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = os.Mkdir(absPath, 0755)
+	if err != nil && !os.IsExist(err) {
+		return Remote.Entry{}, err
+	}
+
+	properties := ConfigFile.PathProperties(localPath)
+	if info, err := os.Stat(localPath); err == nil {
+		properties = Remote.Properties(localPath, info)
+	}
+
+	return localDir.saveMetadata(id, properties, "")
+}
+
+func (localDir *LocalDir) Delete(entry Remote.Entry) error {
+	absPath, err := localDir.absPath(entry.ID)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Remove: ", entry.ID)
+	err = os.RemoveAll(absPath)
+	if err != nil {
+		return err
+	}
+	localDir.removeSidecar(entry.ID)
+
+	err = localDir.journal(entry.ID, true)
+	if err != nil {
+		return err
+	}
+
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+	return nil
+}
+
+func (localDir *LocalDir) Move(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	newId := Remote.Key(localPath)
+	oldPath, err := localDir.absPath(entry.ID)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	newPath, err := localDir.absPath(newId)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	_, err = localDir.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = os.Rename(oldPath, newPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	err = localDir.moveSidecar(entry.ID, newId)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = localDir.journal(entry.ID, true)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+
+	// Paths saved on properties must follow the entry, and its content
+	// when it's a folder
+	moved, err := localDir.refreshPath(newId, localPath)
+	if err != nil || !moved.IsDir {
+		return moved, err
+	}
+
+	err = Remote.WalkTree(localDir, newId, func(child Remote.Entry) error {
+		rel := strings.TrimPrefix(child.ID, newId+"/")
+		_, err := localDir.refreshPath(child.ID, filepath.Join(localPath, filepath.FromSlash(rel)))
+		return err
+	})
+	return moved, err
+}
+
+func (localDir *LocalDir) SetMetadata(entry Remote.Entry, properties map[string]string) (Remote.Entry, error) {
+	return localDir.saveMetadata(entry.ID, properties, "")
+}
+
+// write copies localPath content to id. The content is written to a
+// temporary file and renamed, so other machines never read a partial file.
+func (localDir *LocalDir) write(id string, localPath string, info os.FileInfo) (Remote.Entry, error) {
+	absPath, err := localDir.absPath(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	src, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
+	}
+	defer src.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(absPath), EchoGuard.TempPrefix+"*")
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	// The content is hashed while it's copied
+	md5, err := utils.Md5(io.TeeReader(src, tmpFile))
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = os.Rename(tmpFile.Name(), absPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return localDir.saveMetadata(id, Remote.Properties(localPath, info), md5)
+}
+
+// refreshPath rewrites the path properties of id to localPath.
+func (localDir *LocalDir) refreshPath(id string, localPath string) (Remote.Entry, error) {
+	current, err := localDir.readSidecar(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	properties := map[string]string{}
+	for name, value := range current.Properties {
+		properties[name] = value
+	}
+	for name, value := range ConfigFile.PathProperties(localPath) {
+		properties[name] = value
+	}
+
+	return localDir.saveMetadata(id, properties, current.Md5)
+}
+
+// saveMetadata writes the sidecar of id, saves it on the journal and
+// notifies the saved entry.
+func (localDir *LocalDir) saveMetadata(id string, properties map[string]string, md5 string) (Remote.Entry, error) {
+	absPath, err := localDir.absPath(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		return Remote.Entry{}, Remote.ErrNotFound
+	}
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	metadata, err := localDir.readSidecar(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	now := formatTime(time.Now())
+	if metadata.CreatedTime == "" {
+		metadata.CreatedTime = now
+	}
+	metadata.ModifiedTime = now
+	metadata.Properties = properties
+	if md5 != "" || info.IsDir() {
+		metadata.Md5 = md5
+		metadata.Size = info.Size()
+		metadata.ContentTime = formatTime(info.ModTime())
+	}
+
+	err = localDir.writeSidecar(id, metadata)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = localDir.journal(id, false)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	entry := localDir.toEntry(id, info)
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Saved})
+	return entry, nil
+}
+
+func (localDir *LocalDir) toEntry(id string, info os.FileInfo) Remote.Entry {
+	entry := Remote.Entry{
+		ID:           id,
+		Name:         path.Base(id),
+		ParentID:     path.Dir(id),
+		IsDir:        info.IsDir(),
+		Size:         info.Size(),
+		CreatedTime:  formatTime(info.ModTime()),
+		ModifiedTime: formatTime(info.ModTime()),
+	}
+
+	if entry.IsDir {
+		entry.MimeType = FolderMimeType
+		entry.Size = 0
+	} else if entry.MimeType = mime.TypeByExtension(filepath.Ext(id)); entry.MimeType == "" {
+		entry.MimeType = "application/octet-stream"
+	}
+
+	metadata, err := localDir.readSidecar(id)
+	if err != nil {
+		log.Printf("error reading metadata of %q: %v", id, err)
+		return entry
+	}
+
+	entry.Properties = metadata.Properties
+	if metadata.CreatedTime != "" {
+		entry.CreatedTime = metadata.CreatedTime
+	}
+
+	// Content changed by someone else keeps the newest time and can't
+	// trust the saved md5
+	if metadata.ContentTime == formatTime(info.ModTime()) && metadata.Size == info.Size() {
+		entry.Md5 = metadata.Md5
+		if metadata.ModifiedTime != "" {
+			entry.ModifiedTime = metadata.ModifiedTime
+		}
+	}
+
+	return entry
+}
+
+// absPath returns where id is saved, refusing ids outside root or inside metaDir.
+func (localDir *LocalDir) absPath(id string) (string, error) {
+	id = path.Clean(id)
+	if path.IsAbs(id) || id == ".." || strings.HasPrefix(id, "../") ||
+		id == metaDir || strings.HasPrefix(id, metaDir+"/") {
+		return "", fmt.Errorf("%w: invalid id %q", Remote.ErrNotFound, id)
+	}
+
+	return filepath.Join(localDir.root, filepath.FromSlash(id)), nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,130 @@
+package LocalDir
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/adapters/sqlite"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"testing"
+	"time"
+)
+
+// setup configures a watched dir and a remote dir, both temporary, with
+// their own database.
+func setup(t *testing.T) (*LocalDir, string) {
+	t.Helper()
+
+	watched := t.TempDir()
+	ConfigFile.Configs = ConfigFile.ConfigsStruct{
+		DbPath:     filepath.Join(t.TempDir(), "superpose.db"),
+		WatchPaths: []ConfigFile.WatchPath{{Path: watched}},
+	}
+	sqlite.Connect()
+
+	localDir, err := NewLocalDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repositories.SetScope("local:" + localDir.root); err != nil {
+		t.Fatal(err)
+	}
+
+	return localDir, watched
+}
+
+func init() {
+	// Events are saved on the worktree by SaveRemoteInfo, not needed here
+	go func() {
+		for range Remote.Events {
+		}
+	}()
+}
+
+func TestRoundTrip(t *testing.T) {
+	localDir, watched := setup(t)
+
+	path := filepath.Join(watched, "dir", "file.txt")
+	content := []byte("superpose\n")
+	changedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, changedAt, changedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := localDir.StartCursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := Remote.Send(localDir, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5, _ := utils.Md5(bytes.NewReader(content))
+	if entry.Size != int64(len(content)) || entry.Md5 != md5 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	changes, _, err := localDir.Changes(cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) == 0 || changes[len(changes)-1].ID != entry.ID {
+		t.Fatalf("the upload of %q is not on changes: %+v", entry.ID, changes)
+	}
+
+	// Sending it again unchanged only refreshes its metadata
+	if !Remote.SameContent(path, entry) {
+		t.Fatalf("%q should have the same content of %+v", path, entry)
+	}
+	if _, err = Remote.Send(localDir, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another machine downloads it to the same path
+	if err = os.RemoveAll(filepath.Join(watched, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = localDir.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Remote.Materialize(localDir, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded %q, want %q", downloaded, content)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode is %04o, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(changedAt) {
+		t.Errorf("mtime is %s, want %s", info.ModTime(), changedAt)
+	}
+
+	if err = localDir.Delete(entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = localDir.Stat(path); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Stat after Delete returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,98 @@
+package LocalDir
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"superpose-sync/services/EchoGuard"
+)
+
+// sidecar is the metadata of an entry, saved on
+// "[root]/.superpose/meta/[id].json". Md5 is only valid while the content
+// keeps Size and ContentTime.
+type sidecar struct {
+	CreatedTime  string            `json:"createdTime"`
+	ModifiedTime string            `json:"modifiedTime"`
+	ContentTime  string            `json:"contentTime,omitempty"`
+	Size         int64             `json:"size"`
+	Md5          string            `json:"md5,omitempty"`
+	Properties   map[string]string `json:"properties"`
+}
+
+func (localDir *LocalDir) sidecarPath(id string) string {
+	return filepath.Join(localDir.root, metaDir, "meta", filepath.FromSlash(id)+".json")
+}
+
+// sidecarDir is where the sidecars of a folder content are saved.
+func (localDir *LocalDir) sidecarDir(id string) string {
+	return filepath.Join(localDir.root, metaDir, "meta", filepath.FromSlash(id))
+}
+
+// readSidecar returns an empty sidecar when id has none, as for files
+// copied to root by hand.
+func (localDir *LocalDir) readSidecar(id string) (sidecar, error) {
+	metadata := sidecar{}
+
+	content, err := os.ReadFile(localDir.sidecarPath(id))
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, err
+	}
+
+	err = json.Unmarshal(content, &metadata)
+	return metadata, err
+}
+
+func (localDir *LocalDir) writeSidecar(id string, metadata sidecar) error {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	sidecarPath := localDir.sidecarPath(id)
+	err = os.MkdirAll(filepath.Dir(sidecarPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(sidecarPath), EchoGuard.TempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), sidecarPath)
+}
+
+func (localDir *LocalDir) removeSidecar(id string) {
+	os.Remove(localDir.sidecarPath(id))
+	os.RemoveAll(localDir.sidecarDir(id))
+}
+
+func (localDir *LocalDir) moveSidecar(oldId string, newId string) error {
+	err := os.MkdirAll(filepath.Dir(localDir.sidecarPath(newId)), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(localDir.sidecarPath(oldId), localDir.sidecarPath(newId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Rename(localDir.sidecarDir(oldId), localDir.sidecarDir(newId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package Queue
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Conflicts"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// Upload creates or updates a file on remote
+	Upload = "upload"
+	Delete = "delete"
+	Mkdir  = "mkdir"
+	// Metadata updates only the mode and mtime of a file on remote
+	Metadata = "metadata"
+	// Move moves the remote entry of FromPath to FullPath, keeping its
+	// history
+	Move = "move"
+)
+
+const (
+	// batchSize is how many due items are checked for one that isn't busy
+	batchSize   = 100
+	baseBackoff = 5 * time.Second
+	maxBackoff  = time.Hour
+	// idleWait is how long the worker sleeps when nothing is due and no
+	// item is pushed
+	idleWait = time.Minute
+)
+
+var (
+	wakeUp = make(chan struct{}, 1)
+
+	runningMutex sync.Mutex
+	// running are the paths being processed by workers
+	running = map[string]bool{}
+)
+
+// Push saves an operation on the queue. It survives crashes and restarts
+// until it's done.
+func Push(action string, fullPath string, remoteId string) error {
+	// A pending move sends the content too, and so does a pending upload
+	// with the metadata
+	if action == Upload || action == Metadata {
+		pending, err := repositories.GetQueueItemByPath(fullPath)
+		if err == nil && (pending.Action == Move || (action == Metadata && pending.Action == Upload)) {
+			wake()
+			return nil
+		}
+	}
+
+	_, err := repositories.Enqueue(repositories.QueueItem{
+		Action:   action,
+		FullPath: fullPath,
+		RemoteID: remoteId,
+	})
+	if err != nil {
+		return err
+	}
+
+	wake()
+	return nil
+}
+
+// PushMove saves the move of the remote entry remoteId from fromPath to
+// fullPath.
+func PushMove(fromPath string, fullPath string, remoteId string) error {
+	_, err := repositories.Enqueue(repositories.QueueItem{
+		Action:   Move,
+		FullPath: fullPath,
+		RemoteID: remoteId,
+		FromPath: fromPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	wake()
+	return nil
+}
+
+// PushTree saves the upload of fullPath and, when it's a dir, of everything
+// inside it. Ignored paths, or outside the watchers, are skipped, and
+// symlinks follow the symlinks policy.
+func PushTree(fullPath string) error {
+	symlinks := ConfigFile.GetSymlinks(fullPath)
+	return utils.Walk(fullPath, symlinks == ConfigFile.SymlinksFollow, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if utils.IsSymlink(info) && symlinks == ConfigFile.SymlinksSkip {
+			return nil
+		}
+
+		isIgnored, err := ConfigFile.PathInIgnore(path)
+		if err != nil {
+			return err
+		}
+		inWatchers, err := ConfigFile.PathInWatchers(path)
+		if err != nil {
+			return err
+		}
+		if isIgnored || !inWatchers {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return Push(Mkdir, path, "")
+		}
+		return Push(Upload, path, "")
+	})
+}
+
+func wake() {
+	select {
+	case wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the queue in background with a pool of workers, starting
+// with what was left by the last run. Independent paths run in parallel,
+// but a path never runs together with itself, its parents or its content,
+// so operations on the same file keep their order.
+func Start(remote Remote.Remote) {
+	slots := make(chan struct{}, ConfigFile.GetWorkers())
+	go func() {
+		for {
+			slots <- struct{}{}
+
+			now := time.Now()
+			item, ok := next(now)
+			if !ok {
+				<-slots
+				wait(now)
+				continue
+			}
+
+			go func() {
+				process(remote, item)
+				release(item)
+				<-slots
+			}()
+		}
+	}()
+}
+
+// next claims the oldest item due at now whose path isn't busy.
+func next(now time.Time) (repositories.QueueItem, bool) {
+	items, err := repositories.GetDueQueueItems(now, batchSize)
+	if err != nil {
+		log.Println("Queue.GetDueQueueItems error: ", err)
+		return repositories.QueueItem{}, false
+	}
+
+	runningMutex.Lock()
+	defer runningMutex.Unlock()
+
+	for _, item := range items {
+		if isBusy(item.FullPath) || (item.FromPath != "" && isBusy(item.FromPath)) {
+			continue
+		}
+		running[item.FullPath] = true
+		if item.FromPath != "" {
+			running[item.FromPath] = true
+		}
+		return item, true
+	}
+	return repositories.QueueItem{}, false
+}
+
+func isBusy(path string) bool {
+	for runningPath := range running {
+		if runningPath == path || strings.HasPrefix(path, runningPath+"/") || strings.HasPrefix(runningPath, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// release frees the paths of item and wakes up the dispatcher, items
+// waiting for them may run now.
+func release(item repositories.QueueItem) {
+	runningMutex.Lock()
+	delete(running, item.FullPath)
+	delete(running, item.FromPath)
+	runningMutex.Unlock()
+
+	wake()
+}
+
+// wait sleeps until an item is pushed, a worker is released or the next
+// failed item due after now is due.
+func wait(now time.Time) {
+	timeout := idleWait
+	nextRetry, err := repositories.NextQueueRetry(now)
+	if err == nil && !nextRetry.IsZero() && time.Until(nextRetry) < timeout {
+		timeout = time.Until(nextRetry)
+	}
+
+	select {
+	case <-wakeUp:
+	case <-time.After(timeout):
+	}
+}
+
+func process(remote Remote.Remote, item repositories.QueueItem) {
+	err := Do(remote, item)
+	if err == nil {
+		repositories.DeleteQueueItem(item.ID)
+		return
+	}
+
+	delay := backoff(item.Attempts + 1)
+	log.Printf("queue: %s %q failed on attempt %d, retrying in %s: %v", item.Action, item.FullPath, item.Attempts+1, delay, err)
+	repositories.FailQueueItem(item.ID, err.Error(), time.Now().Add(delay))
+}
+
+// Do runs item on remote.
+func Do(remote Remote.Remote, item repositories.QueueItem) error {
+	switch item.Action {
+	case Upload:
+		// Removed meanwhile, its delete is queued too
+		if _, err := os.Lstat(item.FullPath); os.IsNotExist(err) {
+			return nil
+		}
+		return Conflicts.Upload(remote, item.FullPath)
+	case Delete:
+		if item.RemoteID == "" {
+			return nil
+		}
+		err := remote.Delete(Remote.Entry{ID: item.RemoteID})
+		if err != nil && !errors.Is(err, Remote.ErrNotFound) {
+			return err
+		}
+
+		// Folders are removed with their content
+		err = repositories.DeleteTree(item.FullPath)
+		if err != nil {
+			return err
+		}
+		return repositories.DeleteSynced(item.FullPath)
+	case Metadata:
+		if _, err := os.Lstat(item.FullPath); os.IsNotExist(err) {
+			return nil
+		}
+		_, err := Remote.SendMetadata(remote, item.FullPath)
+		return err
+	case Move:
+		return move(remote, item)
+	case Mkdir:
+		if _, err := os.Stat(item.FullPath); os.IsNotExist(err) {
+			return nil
+		}
+		_, err := remote.Mkdir(item.FullPath)
+		return err
+	}
+
+	log.Printf("queue: dropping unknown action %q of %q", item.Action, item.FullPath)
+	return nil
+}
+
+// move moves the remote entry and its synced state. The content is sent
+// too, it may have changed before the move.
+func move(remote Remote.Remote, item repositories.QueueItem) error {
+	// Removed meanwhile, its delete is queued too
+	if _, err := os.Lstat(item.FullPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	entry, err := remote.Get(item.RemoteID)
+	if errors.Is(err, Remote.ErrNotFound) {
+		log.Printf("queue: %q isn't on remote anymore, uploading %q", item.FromPath, item.FullPath)
+		// The move is replaced by the uploads, it must not hold them back
+		repositories.DeleteQueueItem(item.ID)
+		return PushTree(item.FullPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	moved, err := remote.Move(entry, item.FullPath)
+	if err != nil {
+		return err
+	}
+
+	err = repositories.MoveSynced(item.FromPath, item.FullPath)
+	if err != nil {
+		return err
+	}
+
+	if !moved.IsDir {
+		_, err = Remote.Send(remote, item.FullPath)
+		return err
+	}
+
+	// Entries inside the folder got new path properties, and new ids on
+	// some remotes
+	return Remote.WalkTree(remote, moved.ID, func(child Remote.Entry) error {
+		localPath, err := Remote.LocalPath(child)
+		if err != nil || child.IsDir {
+			return nil
+		}
+		return repositories.SetSyncedRemote(localPath, child.ID, child.ModifiedTime)
+	})
+}
+
+// backoff doubles the delay on each attempt, up to maxBackoff.
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+func List() ([]repositories.QueueItem, error) {
+	return repositories.GetQueue()
+}
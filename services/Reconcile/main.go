@@ -0,0 +1,362 @@
+package Reconcile
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Conflicts"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Queue"
+	"superpose-sync/services/Remote"
+	"superpose-sync/services/RemoteChanges"
+	"superpose-sync/utils"
+)
+
+const (
+	Upload       = "upload"
+	Download     = "download"
+	DeleteLocal  = "delete_local"
+	DeleteRemote = "delete_remote"
+	Track        = "track"
+	Forget       = "forget"
+	Conflict     = "conflict"
+)
+
+// Operation is what must be done with Path to get local and remote in sync again.
+type Operation struct {
+	Action string
+	Path   string
+	FileID string
+	Info   os.FileInfo
+	Entry  Remote.Entry
+}
+
+// Run compares every watched dir against the synced cache and the remote,
+// then applies the differences. It's used on startup to catch up with
+// everything changed while superpose was not running.
+func Run(remote Remote.Remote) error {
+	operations, err := Scan(remote)
+	if err != nil {
+		return err
+	}
+
+	Apply(remote, operations)
+	return nil
+}
+
+func Scan(remote Remote.Remote) ([]Operation, error) {
+	localFiles, err := scanLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteEntries, err := scanRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	syncedFiles, err := repositories.GetAllSynced()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	for path := range localFiles {
+		paths[path] = true
+	}
+	for path := range remoteEntries {
+		paths[path] = true
+	}
+	for path := range syncedFiles {
+		inWatchers, err := ConfigFile.PathInWatchers(path)
+		if err == nil && inWatchers {
+			paths[path] = true
+		}
+	}
+
+	sortedPaths := make([]string, 0, len(paths))
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+
+	operations := []Operation{}
+	for _, path := range sortedPaths {
+		var synced *repositories.Synced
+		if s, ok := syncedFiles[path]; ok {
+			synced = &s
+		}
+
+		var entry *Remote.Entry
+		if e, ok := remoteEntries[path]; ok {
+			entry = &e
+		}
+
+		operation, ok := compare(path, localFiles[path], entry, synced)
+		if ok {
+			operations = append(operations, operation)
+		}
+	}
+
+	if len(remoteEntries) == 0 {
+		operations = keepLocalFiles(operations)
+	}
+
+	missing, err := missingRoots()
+	if err != nil {
+		return nil, err
+	}
+	operations = keepRemoteFiles(operations, missing)
+
+	return operations, nil
+}
+
+// keepLocalFiles drops the local deletes when the remote came back empty,
+// e.g. its root changed or it was wiped: it's much more likely a wrong
+// remote than every file removed on purpose.
+func keepLocalFiles(operations []Operation) []Operation {
+	kept := operations[:0]
+	refused := 0
+	for _, operation := range operations {
+		if operation.Action == DeleteLocal {
+			refused++
+			continue
+		}
+		kept = append(kept, operation)
+	}
+
+	if refused > 0 {
+		log.Printf("reconcile: the remote is empty, refusing to delete %d local files", refused)
+	}
+	return kept
+}
+
+// keepRemoteFiles drops the remote deletes of files inside watched dirs
+// that are missing or empty, e.g. an unmounted disk: it's much more likely
+// than every file removed on purpose.
+func keepRemoteFiles(operations []Operation, missing map[string]bool) []Operation {
+	kept := operations[:0]
+	refused := map[string]int{}
+	for _, operation := range operations {
+		if operation.Action == DeleteRemote {
+			if root, ok := rootOf(operation.Path, missing); ok {
+				refused[root]++
+				continue
+			}
+		}
+		kept = append(kept, operation)
+	}
+
+	for root, count := range refused {
+		log.Printf("reconcile: %q is missing or empty, refusing to delete %d remote files", root, count)
+	}
+	return kept
+}
+
+func compare(path string, local os.FileInfo, remote *Remote.Entry, synced *repositories.Synced) (Operation, bool) {
+	operation := Operation{Path: path, Info: local}
+	if remote != nil {
+		operation.Entry = *remote
+		operation.FileID = remote.ID
+	} else if synced != nil {
+		operation.FileID = synced.FileID
+	}
+
+	localChanged := local != nil && (synced == nil ||
+		local.Size() != synced.Size ||
+		repositories.FormatModTime(local.ModTime()) != synced.ModTime)
+	remoteChanged := remote != nil && (synced == nil || remote.ModifiedTime != synced.RemoteChangedAt)
+
+	switch {
+	case local != nil && remote == nil:
+		operation.Action = Upload
+		if synced != nil && !localChanged {
+			operation.Action = DeleteLocal
+		}
+	case local == nil && remote != nil:
+		operation.Action = Download
+		if synced != nil && !remoteChanged {
+			operation.Action = DeleteRemote
+		}
+	case local != nil && remote != nil:
+		if localChanged && remoteChanged {
+			operation.Action = bothChanged(local, *remote)
+		} else if localChanged {
+			operation.Action = Upload
+		} else if remoteChanged {
+			operation.Action = Download
+		} else {
+			return operation, false
+		}
+	default:
+		operation.Action = Forget
+	}
+
+	return operation, true
+}
+
+// bothChanged is used when both sides changed (or were never synced). Files
+// with same size and mtime are just tracked, otherwise it's a conflict.
+func bothChanged(local os.FileInfo, remote Remote.Entry) string {
+	remoteChangedAt, ok := Remote.ChangedAt(remote)
+	if ok && local.Size() == remote.Size && local.ModTime().Equal(remoteChangedAt) {
+		return Track
+	}
+
+	return Conflict
+}
+
+// Apply runs operations. Uploads and remote deletes are pushed to the
+// queue, so they are retried and ordered with the local changes, and paths
+// the queue still has to send are left alone: the remote is behind them.
+func Apply(remote Remote.Remote, operations []Operation) {
+	pending, err := repositories.GetQueue()
+	if err != nil {
+		log.Println("reconcile: error reading the queue: ", err)
+		return
+	}
+
+	for _, operation := range operations {
+		if isPending(operation.Path, pending) {
+			log.Printf("reconcile: skipping %s %q, it's waiting on the queue", operation.Action, operation.Path)
+			continue
+		}
+
+		log.Printf("reconcile: %s %q", operation.Action, operation.Path)
+
+		var err error
+		switch operation.Action {
+		case Upload:
+			err = Queue.Push(Queue.Upload, operation.Path, operation.FileID)
+		case Download:
+			err = Remote.Materialize(remote, operation.Entry)
+		case DeleteLocal:
+			err = RemoteChanges.ApplyRemoval(operation.FileID)
+		case DeleteRemote:
+			err = Queue.Push(Queue.Delete, operation.Path, operation.FileID)
+		case Track:
+			err = repositories.SaveSynced(operation.Path, operation.Info, operation.FileID, operation.Entry.ModifiedTime)
+		case Forget:
+			err = repositories.DeleteSynced(operation.Path)
+		case Conflict:
+			err = Conflicts.Resolve(remote, operation.Path, operation.Info, operation.Entry)
+		}
+
+		if err != nil {
+			log.Printf("reconcile: error on %s %q: %v", operation.Action, operation.Path, err)
+		}
+	}
+}
+
+// isPending reports whether path, or a dir containing it, is the source or
+// the target of a queue item.
+func isPending(path string, items []repositories.QueueItem) bool {
+	for _, item := range items {
+		for _, pendingPath := range []string{item.FullPath, item.FromPath} {
+			if pendingPath != "" && (path == pendingPath || strings.HasPrefix(path, pendingPath+"/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func scanLocal() (map[string]os.FileInfo, error) {
+	localFiles := map[string]os.FileInfo{}
+	for _, watchPath := range ConfigFile.Configs.WatchPaths {
+		root, err := utils.GetAbsPath(watchPath.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		recursive := watchPath.Recursive == nil || *watchPath.Recursive
+		err = scanRoot(root, recursive, localFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return localFiles, nil
+}
+
+// missingRoots returns the watched dirs that don't exist or are empty.
+func missingRoots() (map[string]bool, error) {
+	missing := map[string]bool{}
+	for _, watchPath := range ConfigFile.Configs.WatchPaths {
+		root, err := utils.GetAbsPath(watchPath.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := os.ReadDir(root)
+		if err != nil || len(entries) == 0 {
+			missing[root] = true
+		}
+	}
+	return missing, nil
+}
+
+// scanRoot adds to localFiles the files of the watched dir root that
+// aren't ignored, and its symlinks when they are preserved.
+func scanRoot(root string, recursive bool, localFiles map[string]os.FileInfo) error {
+	symlinks := ConfigFile.GetSymlinks(root)
+	return utils.Walk(root, symlinks == ConfigFile.SymlinksFollow, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		isIgnored, err := ConfigFile.PathInIgnore(path)
+		if err != nil {
+			return err
+		}
+
+		if f.IsDir() {
+			if isIgnored || (!recursive && path != root) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		synced := f.Mode().IsRegular() || (utils.IsSymlink(f) && symlinks == ConfigFile.SymlinksPreserve)
+		if !isIgnored && synced && !EchoGuard.IsTemp(path) {
+			localFiles[path] = f
+		}
+		return nil
+	})
+}
+
+func scanRemote(remote Remote.Remote) (map[string]Remote.Entry, error) {
+	remoteEntries := map[string]Remote.Entry{}
+	err := Remote.WalkTree(remote, remote.RootID(), func(entry Remote.Entry) error {
+		if entry.IsDir {
+			return nil
+		}
+
+		path, err := Remote.LocalPath(entry)
+		if err != nil {
+			return nil
+		}
+
+		inWatchers, err := ConfigFile.PathInWatchers(path)
+		if err != nil || !inWatchers {
+			return err
+		}
+
+		isIgnored, err := ConfigFile.PathInIgnore(path)
+		if err != nil || isIgnored {
+			return err
+		}
+
+		remoteEntries[path] = entry
+		return nil
+	})
+
+	return remoteEntries, err
+}
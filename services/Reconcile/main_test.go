@@ -0,0 +1,120 @@
+package Reconcile
+
+import (
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"testing"
+	"time"
+)
+
+type fileInfo struct {
+	size    int64
+	modTime time.Time
+}
+
+func (info fileInfo) Name() string       { return "file" }
+func (info fileInfo) Size() int64        { return info.size }
+func (info fileInfo) Mode() os.FileMode  { return 0644 }
+func (info fileInfo) ModTime() time.Time { return info.modTime }
+func (info fileInfo) IsDir() bool        { return false }
+func (info fileInfo) Sys() any           { return nil }
+
+func TestCompare(t *testing.T) {
+	syncedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	editedAt := syncedAt.Add(time.Hour)
+
+	local := fileInfo{size: 10, modTime: syncedAt}
+	localEdited := fileInfo{size: 12, modTime: editedAt}
+	remote := &Remote.Entry{ID: "id", Size: 10, ModifiedTime: "remote-v1", Properties: map[string]string{
+		"changedAt": syncedAt.String(),
+	}}
+	remoteEdited := &Remote.Entry{ID: "id", Size: 12, ModifiedTime: "remote-v2", Properties: map[string]string{
+		"changedAt": editedAt.String(),
+	}}
+	synced := &repositories.Synced{
+		FileID:          "id",
+		Size:            10,
+		ModTime:         repositories.FormatModTime(syncedAt),
+		RemoteChangedAt: "remote-v1",
+	}
+
+	tests := []struct {
+		name   string
+		local  os.FileInfo
+		remote *Remote.Entry
+		synced *repositories.Synced
+		action string
+	}{
+		{"new local file", local, nil, nil, Upload},
+		{"new remote file", nil, remote, nil, Download},
+		{"unchanged", local, remote, synced, ""},
+		{"edited locally", localEdited, remote, synced, Upload},
+		{"edited on remote", local, remoteEdited, synced, Download},
+		{"same edit on both", localEdited, remoteEdited, &repositories.Synced{FileID: "id", RemoteChangedAt: "remote-v0"}, Track},
+		{"edited differently on both", local, remoteEdited, &repositories.Synced{FileID: "id", RemoteChangedAt: "remote-v0"}, Conflict},
+		{"never synced, same file", local, remote, nil, Track},
+		{"never synced, different files", local, remoteEdited, nil, Conflict},
+		{"removed on remote", local, nil, synced, DeleteLocal},
+		{"removed on remote, edited locally", localEdited, nil, synced, Upload},
+		{"removed locally", nil, remote, synced, DeleteRemote},
+		{"removed locally, edited on remote", nil, remoteEdited, synced, Download},
+		{"removed on both", nil, nil, synced, Forget},
+	}
+	for _, test := range tests {
+		operation, ok := compare("/watched/file", test.local, test.remote, test.synced)
+		if !ok {
+			operation.Action = ""
+		}
+		if operation.Action != test.action {
+			t.Errorf("%s: got %q, want %q", test.name, operation.Action, test.action)
+		}
+		if ok && operation.FileID != "id" && (test.remote != nil || test.synced != nil) {
+			t.Errorf("%s: FileID is %q, want %q", test.name, operation.FileID, "id")
+		}
+	}
+}
+
+func TestKeepLocalFiles(t *testing.T) {
+	operations := keepLocalFiles([]Operation{
+		{Path: "/watched/a", Action: DeleteLocal},
+		{Path: "/watched/b", Action: Upload},
+		{Path: "/watched/c", Action: DeleteLocal},
+	})
+	if len(operations) != 1 || operations[0].Path != "/watched/b" {
+		t.Errorf("keepLocalFiles kept %+v, want only the upload of /watched/b", operations)
+	}
+}
+
+func TestKeepRemoteFiles(t *testing.T) {
+	operations := keepRemoteFiles([]Operation{
+		{Path: "/unmounted/a", Action: DeleteRemote},
+		{Path: "/unmounted/b", Action: Download},
+		{Path: "/watched/c", Action: DeleteRemote},
+	}, map[string]bool{"/unmounted": true})
+	if len(operations) != 2 || operations[0].Path != "/unmounted/b" || operations[1].Path != "/watched/c" {
+		t.Errorf("keepRemoteFiles kept %+v, want the download of /unmounted/b and the delete of /watched/c", operations)
+	}
+}
+
+func TestMissingRoots(t *testing.T) {
+	watched := t.TempDir()
+	empty := t.TempDir()
+	gone := filepath.Join(t.TempDir(), "gone")
+	if err := os.WriteFile(filepath.Join(watched, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ConfigFile.Configs = ConfigFile.ConfigsStruct{
+		WatchPaths: []ConfigFile.WatchPath{{Path: watched}, {Path: empty}, {Path: gone}},
+	}
+
+	missing, err := missingRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing[watched] || !missing[empty] || !missing[gone] {
+		t.Errorf("missingRoots returned %v, want %q and %q", missing, empty, gone)
+	}
+}
@@ -0,0 +1,152 @@
+package Reconcile
+
+import (
+	"log"
+	"os"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Queue"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+)
+
+// rescanDelay is how long a dirty root waits before being rescanned, so a
+// burst of changes (like a git checkout) is rescanned once it's over.
+const rescanDelay = 2 * time.Second
+
+var (
+	dirtyMutex sync.Mutex
+	// dirty are the watch roots that may have lost events
+	dirty  = map[string]bool{}
+	rescan = make(chan struct{}, 1)
+)
+
+// MarkDirty flags the watch root as out of sync with the synced cache,
+// e.g. when the inotify queue overflowed. It's rescanned by
+// StartRescanner.
+func MarkDirty(root string) {
+	dirtyMutex.Lock()
+	dirty[root] = true
+	dirtyMutex.Unlock()
+
+	select {
+	case rescan <- struct{}{}:
+	default:
+	}
+}
+
+// StartRescanner rescans in background the roots marked as dirty.
+func StartRescanner() {
+	go func() {
+		for range rescan {
+			time.Sleep(rescanDelay)
+
+			dirtyMutex.Lock()
+			roots := dirty
+			dirty = map[string]bool{}
+			dirtyMutex.Unlock()
+
+			err := Rescan(roots)
+			if err != nil {
+				log.Println("Reconcile.Rescan error: ", err)
+			}
+		}
+	}()
+}
+
+// Rescan compares the files below roots with the synced cache and queues
+// what changed locally since it was synced. Unlike Run it doesn't list the
+// remote, it only catches up with lost local events.
+func Rescan(roots map[string]bool) error {
+	missing, err := missingRoots()
+	if err != nil {
+		return err
+	}
+
+	localFiles := map[string]os.FileInfo{}
+	scanned := map[string]bool{}
+	for _, watchPath := range ConfigFile.Configs.WatchPaths {
+		root, err := utils.GetAbsPath(watchPath.Path)
+		if err != nil {
+			return err
+		}
+		if !roots[root] {
+			continue
+		}
+		if missing[root] {
+			log.Printf("rescan: %q is missing or empty, refusing to delete its remote files", root)
+			continue
+		}
+		scanned[root] = true
+
+		log.Printf("rescan: %q", root)
+		recursive := watchPath.Recursive == nil || *watchPath.Recursive
+		err = scanRoot(root, recursive, localFiles)
+		if err != nil {
+			return err
+		}
+	}
+
+	syncedFiles, err := repositories.GetAllSynced()
+	if err != nil {
+		return err
+	}
+
+	pending, err := repositories.GetQueue()
+	if err != nil {
+		return err
+	}
+
+	for path, info := range localFiles {
+		synced, ok := syncedFiles[path]
+		if (ok && !synced.LocalChanged(info)) || isPending(path, pending) {
+			continue
+		}
+
+		remoteId, _ := repositories.GetIdByPath(path)
+		log.Printf("rescan: %s %q", Upload, path)
+		err = Queue.Push(Queue.Upload, path, remoteId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for path, synced := range syncedFiles {
+		if _, ok := localFiles[path]; ok || !inRoots(path, scanned) || isPending(path, pending) {
+			continue
+		}
+
+		// Only what is really gone, it may be ignored now or just
+		// moved below a dir that isn't watched
+		_, err := os.Lstat(path)
+		if !os.IsNotExist(err) {
+			continue
+		}
+
+		log.Printf("rescan: %s %q", DeleteRemote, path)
+		err = Queue.Push(Queue.Delete, path, synced.FileID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inRoots reports whether path is inside one of the watched dirs in roots.
+func inRoots(path string, roots map[string]bool) bool {
+	_, ok := rootOf(path, roots)
+	return ok
+}
+
+// rootOf returns the watched dir in roots that path is inside of.
+func rootOf(path string, roots map[string]bool) (string, bool) {
+	for root := range roots {
+		if strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return root, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,21 @@
+package Remote
+
+const (
+	Saved   = "saved"
+	Removed = "removed"
+)
+
+// Event tells the worktree cache that Entry was saved or removed on remote.
+// LocalPath is where it was downloaded to, needed by entries without path
+// properties, e.g. created on the Drive web UI.
+type Event struct {
+	Entry     Entry
+	Action    string
+	LocalPath string
+}
+
+var Events = make(chan Event)
+
+func Notify(event Event) {
+	Events <- event
+}
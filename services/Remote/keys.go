@@ -0,0 +1,64 @@
+package Remote
+
+import (
+	"path"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+)
+
+// Remotes without ids of their own, like LocalDir, S3, WebDAV and SFTP,
+// identify entries by their path below root: the remote form of the local
+// path, see ConfigFile.RemotePath, without the leading "/".
+
+// Key returns the id of localPath on remotes identified by path.
+func Key(localPath string) string {
+	return path.Clean(strings.TrimPrefix(ConfigFile.RemotePath(localPath), "/"))
+}
+
+// KeyPath returns the remote form of the path of id, the inverse of Key.
+func KeyPath(id string) string {
+	if ConfigFile.RelativePaths() {
+		return id
+	}
+	return "/" + id
+}
+
+// ParentKey returns the id of the folder containing id, "" for root.
+func ParentKey(id string) string {
+	parent := path.Dir(id)
+	if parent == "." || parent == "/" {
+		return ""
+	}
+	return parent
+}
+
+// MkdirAll creates the folder of localPath and every missing parent on
+// remotes identified by path, following the remote form of the path as
+// GoogleDrive.CreateTree does. mkdir creates a single folder, or returns
+// it when it already exists, once its parent exists.
+func MkdirAll(remote Remote, localPath string, mkdir func(id string, localPath string) (Entry, error)) (Entry, error) {
+	id := Key(localPath)
+	if id == "." {
+		return remote.Get(remote.RootID())
+	}
+
+	if parentId := ParentKey(id); parentId != "" {
+		_, err := MkdirAll(remote, ConfigFile.LocalPath(KeyPath(parentId)), mkdir)
+		if err != nil {
+			return Entry{}, err
+		}
+	}
+
+	return mkdir(id, localPath)
+}
+
+// NotifySaved returns the current entry of id and notifies it as saved.
+func NotifySaved(remote Remote, id string) (Entry, error) {
+	entry, err := remote.Get(id)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	Notify(Event{Entry: entry, Action: Saved})
+	return entry, nil
+}
@@ -0,0 +1,141 @@
+package Remote
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/utils"
+)
+
+// Content is what is sent to remote as the content of a local file.
+type Content interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// linkContent is the content of a preserved symlink, its target.
+type linkContent struct {
+	*strings.Reader
+}
+
+func (linkContent) Close() error {
+	return nil
+}
+
+// LocalInfo returns the info of localPath as it's synced: preserved
+// symlinks are the link itself, other symlinks their target.
+func LocalInfo(localPath string) (os.FileInfo, error) {
+	info, err := os.Lstat(localPath)
+	if err != nil || !utils.IsSymlink(info) || ConfigFile.GetSymlinks(localPath) == ConfigFile.SymlinksPreserve {
+		return info, err
+	}
+	return os.Stat(localPath)
+}
+
+// OpenLocal opens the content of localPath sent to remote, which is the
+// link target for preserved symlinks.
+func OpenLocal(localPath string) (Content, error) {
+	info, err := LocalInfo(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if utils.IsSymlink(info) {
+		target, err := os.Readlink(localPath)
+		if err != nil {
+			return nil, err
+		}
+		return linkContent{strings.NewReader(target)}, nil
+	}
+
+	return os.Open(localPath)
+}
+
+// SkippedSymlink reports whether localPath is a symlink that must not be
+// synced.
+func SkippedSymlink(localPath string) bool {
+	info, err := os.Lstat(localPath)
+	return err == nil && utils.IsSymlink(info) && ConfigFile.GetSymlinks(localPath) == ConfigFile.SymlinksSkip
+}
+
+// mkdirAll creates dir and its missing parents like os.MkdirAll. Each dir
+// created is expected on EchoGuard, otherwise the watcher would send it
+// back with everything downloaded into it.
+func mkdirAll(dir string) error {
+	missing := []string{}
+	for path := dir; path != filepath.Dir(path); path = filepath.Dir(path) {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, path)
+	}
+
+	for _, path := range missing {
+		EchoGuard.Expect(path)
+		defer EchoGuard.Done(path)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Printf("error creating %q: %v", dir, err)
+	}
+	return err
+}
+
+// downloadSymlink recreates on dest the symlink saved on entry. Mode and
+// mtime aren't applied, they would change the target.
+func downloadSymlink(entry Entry, dest string, target string) error {
+	if ConfigFile.GetSymlinks(dest) == ConfigFile.SymlinksSkip {
+		log.Printf("skipping symlink %q: symlinks are skipped", dest)
+		return nil
+	}
+
+	dir := filepath.Dir(dest)
+	err := mkdirAll(dir)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, EchoGuard.TempPrefix+"*")
+	if err != nil {
+		log.Printf("error creating temp file on %q: %v", dir, err)
+		return err
+	}
+	tmpFile.Close()
+	os.Remove(tmpFile.Name())
+
+	err = os.Symlink(target, tmpFile.Name())
+	if err != nil {
+		log.Printf("error creating symlink %q: %v", dest, err)
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	EchoGuard.Expect(dest)
+	defer EchoGuard.Done(dest)
+	err = os.Rename(tmpFile.Name(), dest)
+	if err != nil {
+		log.Printf("error renaming %q to %q: %v", tmpFile.Name(), dest, err)
+		return err
+	}
+
+	// Followed symlinks may point to nothing on this machine
+	info, err := LocalInfo(dest)
+	if err != nil {
+		info, err = os.Lstat(dest)
+	}
+	if err != nil {
+		return err
+	}
+	err = repositories.SaveSynced(dest, info, entry.ID, entry.ModifiedTime)
+	if err != nil {
+		return err
+	}
+
+	Notify(Event{Entry: entry, Action: Saved, LocalPath: dest})
+	return nil
+}
@@ -0,0 +1,109 @@
+package Remote
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/utils"
+	"time"
+)
+
+// changedAtLayout is the format written by Properties (time.Time.String())
+const changedAtLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Properties returns the metadata saved with localPath on remote, so other
+// machines can restore it.
+func Properties(localPath string, info os.FileInfo) map[string]string {
+	properties := ConfigFile.PathProperties(localPath)
+	properties["mode"] = fmt.Sprintf("%04o", info.Mode().Perm())
+	properties["changedAt"] = info.ModTime().String()
+	if utils.IsSymlink(info) {
+		properties["symlink"], _ = os.Readlink(localPath)
+	}
+
+	return properties
+}
+
+// ApplyMetadata restores on path the permission bits and modification time
+// saved on entry properties. dest is where path will end up, it's used to
+// pick sane defaults when properties are missing or malformed, as for
+// files uploaded through Drive web UI.
+func ApplyMetadata(path string, dest string, entry Entry) error {
+	mode, ok := parseMode(entry.Properties["mode"])
+	if !ok {
+		mode = fallbackMode(dest, entry.IsDir)
+		log.Printf("warning: %q has no valid mode on properties, using %04o", dest, mode)
+	}
+
+	err := os.Chmod(path, mode)
+	if err != nil {
+		log.Printf("error applying mode %04o on %q: %v", mode, dest, err)
+		return err
+	}
+
+	changedAt, ok := ChangedAt(entry)
+	if !ok {
+		log.Printf("warning: %q has no valid changedAt on properties, keeping current mtime", dest)
+		return nil
+	}
+
+	err = os.Chtimes(path, changedAt, changedAt)
+	if err != nil {
+		log.Printf("error applying mtime %s on %q: %v", changedAt, dest, err)
+		return err
+	}
+
+	return nil
+}
+
+func parseMode(strMode string) (os.FileMode, bool) {
+	if strMode == "" {
+		return 0, false
+	}
+
+	mode, err := strconv.ParseUint(strMode, 8, 32)
+	if err != nil || mode > uint64(os.ModePerm) {
+		return 0, false
+	}
+
+	return os.FileMode(mode), true
+}
+
+// ChangedAt returns the local mtime saved on entry properties, falling
+// back to the remote modified time.
+func ChangedAt(entry Entry) (time.Time, bool) {
+	changedAt, err := time.Parse(changedAtLayout, entry.Properties["changedAt"])
+	if err == nil {
+		return changedAt, true
+	}
+
+	changedAt, err = time.Parse(time.RFC3339, entry.ModifiedTime)
+	if err == nil {
+		return changedAt, true
+	}
+
+	return time.Time{}, false
+}
+
+// fallbackMode keeps the mode of an existing local file. New files inherit
+// the parent folder permissions, so a file inside ~/.ssh (0700) becomes 0600.
+func fallbackMode(dest string, isDir bool) os.FileMode {
+	info, err := os.Stat(dest)
+	if err == nil {
+		return info.Mode().Perm()
+	}
+
+	mode := os.FileMode(0755)
+	parentInfo, err := os.Stat(filepath.Dir(dest))
+	if err == nil {
+		mode = parentInfo.Mode().Perm()
+	}
+
+	if isDir {
+		return mode
+	}
+	return mode &^ 0111
+}
@@ -0,0 +1,121 @@
+package Remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrNotFound        = errors.New("remote: path doesn't exists")
+	ErrUnknownPath     = errors.New("remote: unable to find a local path for entry")
+	ErrNotDownloadable = errors.New("remote: entry content can't be downloaded")
+	ErrUnknownRemote   = errors.New("remote: unknown remote")
+	ErrNotSupported    = errors.New("remote: operation not supported")
+)
+
+// Entry is a file or folder saved on a remote. Size is -1 when unknown,
+// e.g. on entries cached before sizes were saved.
+type Entry struct {
+	ID           string
+	Name         string
+	ParentID     string
+	IsDir        bool
+	MimeType     string
+	Size         int64
+	Md5          string
+	CreatedTime  string
+	ModifiedTime string
+	Trashed      bool
+	// Properties are the metadata saved with every entry, as Drive
+	// appProperties: fullPath, mode, changedAt...
+	Properties map[string]string
+}
+
+// Change is something that happened on remote after a cursor.
+type Change struct {
+	ID      string
+	Removed bool
+	Entry   *Entry
+}
+
+// Remote is where the watched dirs are synced to. Paths received by its
+// methods are always local absolute paths, each Remote decides how they
+// are saved. Every entry created, updated, found or removed must be sent
+// with Notify, so the worktree cache is kept up to date.
+type Remote interface {
+	// Name identifies the remote on state saved in SQLite
+	Name() string
+	// RootID is the folder where everything is saved
+	RootID() string
+	// Stat returns the entry of localPath, or ErrNotFound
+	Stat(localPath string) (Entry, error)
+	// Get returns the current metadata of the entry id
+	Get(id string) (Entry, error)
+	// List returns the entries directly inside the folder id
+	List(id string) ([]Entry, error)
+	// Upload creates localPath on remote, with its parent folders
+	Upload(localPath string) (Entry, error)
+	// Update replaces content and metadata of entry with localPath
+	Update(entry Entry, localPath string) (Entry, error)
+	// Download writes the content of entry to w
+	Download(entry Entry, w io.Writer) error
+	// Mkdir creates the folder localPath, with its parents
+	Mkdir(localPath string) (Entry, error)
+	// Delete removes entry, and everything inside it when it's a folder
+	Delete(entry Entry) error
+	// Move moves and renames entry to localPath
+	Move(entry Entry, localPath string) (Entry, error)
+	// SetMetadata replaces the properties of entry, without touching its content
+	SetMetadata(entry Entry, properties map[string]string) (Entry, error)
+	// StartCursor returns the cursor pointing to now
+	StartCursor() (string, error)
+	// Changes returns what happened below RootID since cursor and the next
+	// cursor. Remotes without a changes feed return ErrNotSupported.
+	Changes(cursor string) ([]Change, string, error)
+}
+
+// Factory creates a Remote from the loaded configs.
+type Factory func() (Remote, error)
+
+var (
+	factoriesMutex sync.Mutex
+	factories      = map[string]Factory{}
+)
+
+// Register makes a Remote available by name, it's called from the init of
+// each remote package.
+func Register(name string, factory Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic("remote: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+func New(name string) (Remote, error) {
+	factoriesMutex.Lock()
+	factory, ok := factories[name]
+	factoriesMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q, available: %v", ErrUnknownRemote, name, Names())
+	}
+	return factory()
+}
+
+func Names() []string {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,337 @@
+package Remote
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/utils"
+)
+
+// Send uploads localPath, updating the existing entry when there is one.
+func Send(remote Remote, localPath string) (Entry, error) {
+	if SkippedSymlink(localPath) {
+		log.Printf("skipping symlink %q: symlinks are skipped", localPath)
+		return Entry{}, nil
+	}
+
+	info, err := LocalInfo(localPath)
+	if err != nil {
+		log.Printf("error on stat %q: %v", localPath, err)
+		return Entry{}, err
+	}
+
+	entry, err := remote.Stat(localPath)
+	if err == nil && SameContent(localPath, entry) {
+		entry, err = sendMetadata(remote, entry, localPath, info)
+	} else if err == nil {
+		entry, err = remote.Update(entry, localPath)
+	} else if errors.Is(err, ErrNotFound) {
+		entry, err = remote.Upload(localPath)
+	}
+	if err != nil {
+		log.Printf("error sending %q: %v", localPath, err)
+		return entry, err
+	}
+
+	return entry, repositories.SaveSynced(localPath, info, entry.ID, entry.ModifiedTime)
+}
+
+// SendMetadata updates only the metadata of localPath on remote, e.g.
+// after a chmod or touch. Files never synced, or whose size changed since,
+// are sent whole.
+func SendMetadata(remote Remote, localPath string) (Entry, error) {
+	info, err := LocalInfo(localPath)
+	if err != nil {
+		log.Printf("error on stat %q: %v", localPath, err)
+		return Entry{}, err
+	}
+
+	synced, err := repositories.GetSynced(localPath)
+	if err != nil || synced.Size != info.Size() {
+		return Send(remote, localPath)
+	}
+
+	entry, err := remote.Stat(localPath)
+	if errors.Is(err, ErrNotFound) {
+		return Send(remote, localPath)
+	}
+	if err == nil {
+		entry, err = sendMetadata(remote, entry, localPath, info)
+	}
+	if err != nil {
+		log.Printf("error sending metadata of %q: %v", localPath, err)
+		return entry, err
+	}
+
+	return entry, repositories.SaveSynced(localPath, info, entry.ID, entry.ModifiedTime)
+}
+
+// SameContent reports whether entry already has the content of localPath.
+func SameContent(localPath string, entry Entry) bool {
+	if entry.Md5 == "" {
+		return false
+	}
+
+	info, err := LocalInfo(localPath)
+	if err != nil || (entry.Size >= 0 && info.Size() != entry.Size) {
+		return false
+	}
+
+	localMd5, err := LocalMd5(localPath, info)
+	return err == nil && localMd5 == entry.Md5
+}
+
+// LocalMd5 returns the md5 of the content of localPath, which is hashed
+// again only when its size or mtime changed.
+func LocalMd5(localPath string, info os.FileInfo) (string, error) {
+	if md5, ok := repositories.GetLocalHash(localPath, info); ok {
+		return md5, nil
+	}
+
+	content, err := OpenLocal(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	md5, err := utils.Md5(content)
+	if err != nil {
+		return "", err
+	}
+
+	_ = repositories.SaveLocalHash(localPath, info, md5)
+	return md5, nil
+}
+
+// sendMetadata updates only the mode and mtime of entry, when they differ
+// from localPath, since the content is the same.
+func sendMetadata(remote Remote, entry Entry, localPath string, info os.FileInfo) (Entry, error) {
+	// Cached entries don't have properties
+	if entry.Properties == nil {
+		current, err := remote.Get(entry.ID)
+		if err != nil {
+			return entry, err
+		}
+		entry = current
+	}
+
+	properties := Properties(localPath, info)
+	changedAt, ok := ChangedAt(entry)
+	if ok && changedAt.Equal(info.ModTime()) && entry.Properties["mode"] == properties["mode"] {
+		log.Printf("skipping %q: not changed", localPath)
+		return entry, nil
+	}
+
+	log.Printf("updating metadata of %q: content not changed", localPath)
+	return remote.SetMetadata(entry, properties)
+}
+
+// LocalPath returns where entry must be saved on this machine.
+func LocalPath(entry Entry) (string, error) {
+	if localPath := ConfigFile.LocalPathFromProperties(entry.Properties); localPath != "" {
+		return localPath, nil
+	}
+
+	// Files created outside superpose (e.g. Drive web UI) don't have
+	// properties, so they are placed inside their parent folder
+	if entry.Name == "" || entry.Name == "." || entry.Name == ".." || strings.Contains(entry.Name, "/") {
+		return "", ErrUnknownPath
+	}
+
+	parent, err := repositories.GetPathById(entry.ParentID)
+	if err == nil && parent.FullPath != "" {
+		return filepath.Join(parent.FullPath, entry.Name), nil
+	}
+
+	return "", ErrUnknownPath
+}
+
+// Materialize downloads entry to its local path. Entries outside the
+// watched dirs, or ignored, are skipped.
+func Materialize(remote Remote, entry Entry) error {
+	localPath, err := LocalPath(entry)
+	if err != nil {
+		log.Printf("skipping remote entry %q: %v", entry.Name, err)
+		return nil
+	}
+
+	inWatchers, err := ConfigFile.PathInWatchers(localPath)
+	if err != nil {
+		return err
+	}
+	if !inWatchers {
+		log.Printf("skipping remote entry %q: not inside a watched dir", localPath)
+		return nil
+	}
+
+	isIgnored, err := ConfigFile.PathInIgnore(localPath)
+	if err != nil || isIgnored {
+		return err
+	}
+
+	log.Printf("downloading %q to %q", entry.ID, localPath)
+	return Download(remote, entry, localPath)
+}
+
+// Download saves entry content on dest, creating folders when needed. The
+// content is written to a temporary file and renamed over dest, so a
+// partial download never replaces a good local file. Mode and mtime saved
+// on properties are applied before the rename.
+func Download(remote Remote, entry Entry, dest string) error {
+	if target, ok := entry.Properties["symlink"]; ok && !entry.IsDir {
+		return downloadSymlink(entry, dest, target)
+	}
+
+	if entry.IsDir {
+		err := mkdirAll(dest)
+		if err != nil {
+			return err
+		}
+
+		EchoGuard.Expect(dest)
+		defer EchoGuard.Done(dest)
+
+		err = ApplyMetadata(dest, dest, entry)
+		if err != nil {
+			return err
+		}
+
+		Notify(Event{Entry: entry, Action: Saved, LocalPath: dest})
+		return nil
+	}
+
+	dir := filepath.Dir(dest)
+	err := mkdirAll(dir)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(dir, EchoGuard.TempPrefix+"*")
+	if err != nil {
+		log.Printf("error creating temp file on %q: %v", dir, err)
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = remote.Download(entry, tmpFile)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, ErrNotDownloadable) {
+		log.Printf("skipping download of %q: %v", entry.Name, err)
+		return nil
+	}
+	if err != nil {
+		log.Printf("error downloading %q: %v", entry.ID, err)
+		return err
+	}
+
+	err = ApplyMetadata(tmpFile.Name(), dest, entry)
+	if err != nil {
+		return err
+	}
+
+	EchoGuard.Expect(dest)
+	defer EchoGuard.Done(dest)
+	err = os.Rename(tmpFile.Name(), dest)
+	if err != nil {
+		log.Printf("error renaming %q to %q: %v", tmpFile.Name(), dest, err)
+		return err
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		return err
+	}
+	err = repositories.SaveSynced(dest, info, entry.ID, entry.ModifiedTime)
+	if err != nil {
+		return err
+	}
+
+	Notify(Event{Entry: entry, Action: Saved, LocalPath: dest})
+	return nil
+}
+
+// WalkTree calls walkFn for every entry below the folder id. Folders are
+// always visited before their content.
+func WalkTree(remote Remote, id string, walkFn func(entry Entry) error) error {
+	entries, err := remote.List(id)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = walkFn(entry)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir {
+			err = WalkTree(remote, entry.ID, walkFn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// MigratePathScheme rewrites properties of entries uploaded with absolute
+// paths once the relative path_scheme is enabled. Only paths belonging to
+// this machine can be translated, other machines migrate their own files.
+func MigratePathScheme(remote Remote) error {
+	if !ConfigFile.RelativePaths() {
+		return nil
+	}
+
+	stateKey := remote.Name() + ".path_scheme"
+	migratedScheme, err := repositories.GetState(stateKey)
+	if err != nil || migratedScheme == ConfigFile.PathSchemeRelative {
+		return err
+	}
+
+	log.Println("migrating remote paths to the relative path_scheme")
+	err = WalkTree(remote, remote.RootID(), func(entry Entry) error {
+		localPath := ConfigFile.LocalPathFromProperties(entry.Properties)
+		if localPath == "" {
+			return nil
+		}
+
+		pathProperties := ConfigFile.PathProperties(localPath)
+		if !propertiesChanged(entry.Properties, pathProperties) {
+			return nil
+		}
+
+		properties := map[string]string{}
+		for key, value := range entry.Properties {
+			properties[key] = value
+		}
+		for key, value := range pathProperties {
+			properties[key] = value
+		}
+
+		log.Printf("migrating %q to %q", entry.Properties["fullPath"], properties["fullPath"])
+		_, err := remote.SetMetadata(entry, properties)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return repositories.SetState(stateKey, ConfigFile.PathSchemeRelative)
+}
+
+func propertiesChanged(current map[string]string, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if current[key] != value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package RemoteChanges
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Conflicts"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Queue"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"time"
+)
+
+// StartPoller keeps checking the remote for changes made by other
+// workstations and applies them locally. Remotes without a changes feed
+// are compared with rescan instead, e.g. Reconcile.Run, every
+// rescan_interval since listing the whole remote is expensive. The first
+// rescan waits a full interval, the remote was just compared on startup.
+func StartPoller(remote Remote.Remote, rescan func(Remote.Remote) error) {
+	go func() {
+		lastRescan := time.Now()
+		for {
+			err := Poll(remote)
+			if errors.Is(err, Remote.ErrNotSupported) {
+				err = nil
+				if time.Since(lastRescan) >= ConfigFile.GetRescanInterval() {
+					lastRescan = time.Now()
+					err = rescan(remote)
+				}
+			}
+			if err != nil {
+				log.Println("RemoteChanges.Poll error: ", err)
+			}
+			time.Sleep(ConfigFile.GetPollInterval())
+		}
+	}()
+}
+
+// maxChangeAttempts is how many polls a failed change is tried on before
+// it's skipped, so one that can't be applied, e.g. a local dir without
+// permission, doesn't hold the changes after it forever.
+const maxChangeAttempts = 5
+
+// failedChanges counts the failed attempts of each change id
+var failedChanges = map[string]int{}
+
+func cursorKey(remote Remote.Remote) string {
+	return remote.Name() + ".changes_page_token"
+}
+
+// Poll applies every change made since the last saved cursor. On the first
+// run it only saves the current cursor.
+func Poll(remote Remote.Remote) error {
+	cursor, err := repositories.GetState(cursorKey(remote))
+	if err != nil {
+		return err
+	}
+
+	if cursor == "" {
+		cursor, err = remote.StartCursor()
+		if err != nil {
+			return err
+		}
+		return repositories.SetState(cursorKey(remote), cursor)
+	}
+
+	changes, newCursor, err := remote.Changes(cursor)
+	if err != nil {
+		return err
+	}
+
+	// The cursor is only saved once every change is applied, so a failed
+	// one is tried again on the next poll, up to maxChangeAttempts. Those
+	// applied before it are skipped, the worktree cache already has them.
+	for _, change := range changes {
+		err = applyChange(remote, change)
+		if err == nil {
+			delete(failedChanges, change.ID)
+			continue
+		}
+
+		failedChanges[change.ID]++
+		if failedChanges[change.ID] < maxChangeAttempts {
+			return err
+		}
+
+		log.Printf("skipping remote change of %q after %d attempts: %v", change.ID, failedChanges[change.ID], err)
+		delete(failedChanges, change.ID)
+	}
+
+	return repositories.SetState(cursorKey(remote), newCursor)
+}
+
+func applyChange(remote Remote.Remote, change Remote.Change) error {
+	entry := change.Entry
+	if change.Removed || entry == nil || entry.Trashed {
+		return ApplyRemoval(change.ID)
+	}
+
+	// Our own uploads come back as changes, too
+	cached, err := repositories.GetPathById(entry.ID)
+	if err == nil && cached.ChangedAt == entry.ModifiedTime {
+		return nil
+	}
+
+	err = Conflicts.Download(remote, *entry)
+	if err != nil {
+		log.Printf("error applying remote change of %q: %v", entry.Name, err)
+	}
+	return err
+}
+
+// ApplyRemoval deletes (or moves to quarantine_path) the local copy of a
+// file removed or trashed on remote. Only files known by the worktree cache
+// are touched, so anything outside root_folder_id is left alone, and files
+// changed locally since they were synced are sent again instead.
+func ApplyRemoval(fileId string) error {
+	cached, err := repositories.GetPathById(fileId)
+	if err != nil {
+		return nil
+	}
+
+	localPath := utils.GetAbsPathLocal(cached.FullPath)
+	inWatchers, err := ConfigFile.PathInWatchers(localPath)
+	if err != nil || !inWatchers {
+		log.Printf("skipping remote removal of %q: not inside a watched dir", localPath)
+		return nil
+	}
+
+	changed, err := changedSinceSynced(localPath)
+	if err != nil {
+		return err
+	}
+
+	EchoGuard.ExpectTree(localPath)
+	if len(changed) > 0 {
+		err = removeUnchanged(localPath, changed)
+	} else {
+		err = remove(localPath)
+	}
+	if err != nil {
+		log.Printf("error applying remote removal of %q: %v", localPath, err)
+		return err
+	}
+
+	repositories.Delete(cached)
+	if cached.IsDir == 1 {
+		repositories.DeleteTree(cached.FullPath)
+	}
+	err = repositories.DeleteSynced(localPath)
+	if err != nil {
+		return err
+	}
+
+	// Removed on remote before their edits were synced, they are new
+	// files now
+	for _, path := range changed {
+		log.Printf("remote removal: %q changed locally since it was synced, sending it again", path)
+		err = Queue.Push(Queue.Upload, path, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedSinceSynced returns localPath, or the files below it, when they
+// changed since they were last synced or were never synced. Ignored files
+// and skipped symlinks aren't synced at all, they are never returned.
+func changedSinceSynced(localPath string) ([]string, error) {
+	changed := []string{}
+	err := filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || EchoGuard.IsTemp(path) || Remote.SkippedSymlink(path) {
+			return nil
+		}
+
+		isIgnored, err := ConfigFile.PathInIgnore(path)
+		if err != nil || isIgnored {
+			return err
+		}
+
+		local, err := Remote.LocalInfo(path)
+		if err != nil {
+			return err
+		}
+		synced, err := repositories.GetSynced(path)
+		if err != nil || synced.LocalChanged(local) {
+			changed = append(changed, path)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// remove deletes localPath, or moves it to quarantine_path.
+func remove(localPath string) error {
+	if ConfigFile.Configs.QuarantinePath != "" {
+		return quarantine(localPath)
+	}
+
+	log.Printf("remote removal: deleting %q", localPath)
+	return os.RemoveAll(localPath)
+}
+
+// removeUnchanged removes the files below localPath that aren't in
+// changed, and then the dirs left empty.
+func removeUnchanged(localPath string, changed []string) error {
+	keep := map[string]bool{}
+	for _, path := range changed {
+		keep[path] = true
+	}
+
+	dirs := []string{}
+	err := filepath.Walk(localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if keep[path] {
+			return nil
+		}
+		return remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Content comes before its dir, a dir still holding a changed file
+	// isn't empty and stays
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return nil
+}
+
+// quarantine moves localPath to quarantine_path keeping its full path, e.g.
+// /home/user/.ssh/config goes to [quarantine_path]/20060102-150405/home/user/.ssh/config
+func quarantine(localPath string) error {
+	if _, err := os.Lstat(localPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	quarantinePath, err := utils.GetAbsPath(ConfigFile.Configs.QuarantinePath)
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(quarantinePath, time.Now().Format("20060102-150405"), localPath)
+	err = os.MkdirAll(filepath.Dir(dest), 0700)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("remote removal: moving %q to %q", localPath, dest)
+	return os.Rename(localPath, dest)
+}
@@ -0,0 +1,68 @@
+package RemoteChanges
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+)
+
+var ErrTargetNotEmpty = errors.New("pull: watched dir is not empty, use --force to overwrite it")
+
+// Pull downloads the whole remote tree, applying saved metadata and seeding
+// the worktree cache. Watched dirs must be empty unless force is set.
+func Pull(remote Remote.Remote, force bool) error {
+	if !force {
+		for _, path := range ConfigFile.Configs.WatchPaths {
+			strPath, err := utils.GetAbsPath(path.Path)
+			if err != nil {
+				return err
+			}
+
+			isEmpty, err := dirIsEmpty(strPath)
+			if err != nil {
+				return err
+			}
+			if !isEmpty {
+				return fmt.Errorf("%w: %s", ErrTargetNotEmpty, strPath)
+			}
+		}
+	}
+
+	// Cursor is taken before walking, so anything changed during the pull is
+	// applied by the poller later
+	cursor, err := remote.StartCursor()
+	if err != nil && !errors.Is(err, Remote.ErrNotSupported) {
+		return err
+	}
+
+	err = Remote.WalkTree(remote, remote.RootID(), func(entry Remote.Entry) error {
+		return Remote.Materialize(remote, entry)
+	})
+	if err != nil || cursor == "" {
+		return err
+	}
+
+	return repositories.SetState(cursorKey(remote), cursor)
+}
+
+func dirIsEmpty(path string) (bool, error) {
+	dir, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer dir.Close()
+
+	_, err = dir.Readdirnames(1)
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+	return false, err
+}
@@ -0,0 +1,415 @@
+package S3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/services/Remote"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	RemoteName = "s3"
+	// FolderMimeType is set on the empty objects used as folders, their
+	// keys end with "/"
+	FolderMimeType = "application/x-directory"
+)
+
+var ErrNoBucket = errors.New("s3: bucket doesn't exists")
+
+// S3 saves each watched file as an object under bucket/prefix, with the
+// metadata saved on Drive appProperties as x-amz-meta-* headers. Entries
+// are identified by their key relative to prefix.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+var _ Remote.Remote = (*S3)(nil)
+
+func init() {
+	Remote.Register(RemoteName, func() (Remote.Remote, error) {
+		return NewS3(ConfigFile.Configs.S3)
+	})
+}
+
+func NewS3(configs ConfigFile.S3) (*S3, error) {
+	log.Printf("Initiating S3 on %q, bucket %q", configs.Endpoint, configs.Bucket)
+	client, err := minio.New(configs.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(configs.AccessKey, configs.SecretKey, ""),
+		Secure: !configs.Insecure,
+		Region: configs.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(context.Background(), configs.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrNoBucket, configs.Bucket)
+	}
+
+	prefix := strings.Trim(configs.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &S3{client: client, bucket: configs.Bucket, prefix: prefix}, nil
+}
+
+func (s3 *S3) Name() string {
+	return RemoteName
+}
+
+func (s3 *S3) RootID() string {
+	return ""
+}
+
+func (s3 *S3) Stat(localPath string) (Remote.Entry, error) {
+	entry, err := s3.Get(Remote.Key(localPath))
+	if errors.Is(err, Remote.ErrNotFound) {
+		return s3.Get(Remote.Key(localPath) + "/")
+	}
+	return entry, err
+}
+
+func (s3 *S3) Get(id string) (Remote.Entry, error) {
+	if id == "" {
+		return Remote.Entry{ID: id, IsDir: true, MimeType: FolderMimeType}, nil
+	}
+
+	object, err := s3.client.StatObject(context.Background(), s3.bucket, s3.prefix+id, minio.StatObjectOptions{})
+	if err != nil {
+		return Remote.Entry{}, toError(err)
+	}
+	return s3.toEntry(object), nil
+}
+
+// List returns the objects directly inside id. Folders created by other
+// tools have no folder object, so they come without properties.
+func (s3 *S3) List(id string) ([]Remote.Entry, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	entries := []Remote.Entry{}
+	// Folder objects may come both as object and as common prefix
+	seen := map[string]bool{id: true}
+	for object := range s3.client.ListObjects(ctx, s3.bucket, minio.ListObjectsOptions{Prefix: s3.prefix + id}) {
+		if object.Err != nil {
+			return nil, toError(object.Err)
+		}
+
+		childId := strings.TrimPrefix(object.Key, s3.prefix)
+		if seen[childId] {
+			continue
+		}
+		seen[childId] = true
+
+		entry, err := s3.Get(childId)
+		if errors.Is(err, Remote.ErrNotFound) && strings.HasSuffix(childId, "/") {
+			entry = s3.toEntry(minio.ObjectInfo{Key: object.Key})
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s3 *S3) Upload(localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	if info.IsDir() {
+		return s3.Mkdir(localPath)
+	}
+
+	_, err = s3.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return s3.put(Remote.Key(localPath), localPath, info)
+}
+
+func (s3 *S3) Update(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return s3.put(entry.ID, localPath, info)
+}
+
+func (s3 *S3) Download(entry Remote.Entry, w io.Writer) error {
+	if entry.IsDir {
+		return Remote.ErrNotDownloadable
+	}
+
+	object, err := s3.client.GetObject(context.Background(), s3.bucket, s3.prefix+entry.ID, minio.GetObjectOptions{})
+	if err != nil {
+		return toError(err)
+	}
+	defer object.Close()
+
+	_, err = io.Copy(w, object)
+	return toError(err)
+}
+
+func (s3 *S3) Mkdir(localPath string) (Remote.Entry, error) {
+	return Remote.MkdirAll(s3, localPath, s3.mkdir)
+}
+
+// mkdir creates the folder object of id, its parent already exists.
+func (s3 *S3) mkdir(id string, localPath string) (Remote.Entry, error) {
+	entry, err := s3.Get(id + "/")
+	if err == nil || !errors.Is(err, Remote.ErrNotFound) {
+		return entry, err
+	}
+
+	properties := ConfigFile.PathProperties(localPath)
+	if info, err := os.Stat(localPath); err == nil {
+		properties = Remote.Properties(localPath, info)
+	}
+
+	_, err = s3.client.PutObject(context.Background(), s3.bucket, s3.prefix+id+"/", strings.NewReader(""), 0, minio.PutObjectOptions{
+		ContentType:  FolderMimeType,
+		UserMetadata: toMetadata(properties),
+	})
+	if err != nil {
+		return Remote.Entry{}, toError(err)
+	}
+
+	return Remote.NotifySaved(s3, id+"/")
+}
+
+// Delete removes entry, and every object below it when it's a folder.
+func (s3 *S3) Delete(entry Remote.Entry) error {
+	log.Println("Remove: ", entry.ID)
+
+	ids := []string{entry.ID}
+	if strings.HasSuffix(entry.ID, "/") {
+		var err error
+		ids, err = s3.tree(entry.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, id := range ids {
+		err := s3.client.RemoveObject(context.Background(), s3.bucket, s3.prefix+id, minio.RemoveObjectOptions{})
+		if err != nil {
+			return toError(err)
+		}
+	}
+
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+	return nil
+}
+
+// Move copies entry, and everything below it when it's a folder, to
+// localPath and removes the old objects, since S3 has no rename.
+func (s3 *S3) Move(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	newId := Remote.Key(localPath)
+	if !strings.HasSuffix(entry.ID, "/") {
+		_, err := s3.Mkdir(filepath.Dir(localPath))
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+
+		moved, err := s3.copy(entry.ID, newId, localPath)
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+		return moved, s3.Delete(entry)
+	}
+
+	ids, err := s3.tree(entry.ID)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	_, err = s3.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	var moved Remote.Entry
+	for _, id := range ids {
+		rel := strings.TrimPrefix(id, entry.ID)
+		childPath := filepath.Join(localPath, filepath.FromSlash(rel))
+
+		childEntry, err := s3.copy(id, newId+"/"+rel, childPath)
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+		if id == entry.ID {
+			moved = childEntry
+		}
+	}
+
+	return moved, s3.Delete(entry)
+}
+
+func (s3 *S3) SetMetadata(entry Remote.Entry, properties map[string]string) (Remote.Entry, error) {
+	_, err := s3.client.CopyObject(context.Background(), minio.CopyDestOptions{
+		Bucket:          s3.bucket,
+		Object:          s3.prefix + entry.ID,
+		UserMetadata:    toMetadata(properties),
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket: s3.bucket,
+		Object: s3.prefix + entry.ID,
+	})
+	if err != nil {
+		return Remote.Entry{}, toError(err)
+	}
+
+	return Remote.NotifySaved(s3, entry.ID)
+}
+
+// StartCursor isn't supported, S3 has no changes feed. Other machines
+// changes are found by rescanning the bucket.
+func (s3 *S3) StartCursor() (string, error) {
+	return "", Remote.ErrNotSupported
+}
+
+func (s3 *S3) Changes(cursor string) ([]Remote.Change, string, error) {
+	return nil, "", Remote.ErrNotSupported
+}
+
+func (s3 *S3) put(id string, localPath string, info os.FileInfo) (Remote.Entry, error) {
+	contentType := mime.TypeByExtension(filepath.Ext(localPath))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	content, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
+	}
+	defer content.Close()
+
+	_, err = s3.client.PutObject(context.Background(), s3.bucket, s3.prefix+id, content, info.Size(), minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: toMetadata(Remote.Properties(localPath, info)),
+	})
+	if err != nil {
+		return Remote.Entry{}, toError(err)
+	}
+
+	return Remote.NotifySaved(s3, id)
+}
+
+// copy copies the object id to newId, with the path properties of localPath.
+func (s3 *S3) copy(id string, newId string, localPath string) (Remote.Entry, error) {
+	entry, err := s3.Get(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	properties := map[string]string{}
+	for name, value := range entry.Properties {
+		properties[name] = value
+	}
+	for name, value := range ConfigFile.PathProperties(localPath) {
+		properties[name] = value
+	}
+
+	_, err = s3.client.CopyObject(context.Background(), minio.CopyDestOptions{
+		Bucket:          s3.bucket,
+		Object:          s3.prefix + newId,
+		UserMetadata:    toMetadata(properties),
+		ReplaceMetadata: true,
+	}, minio.CopySrcOptions{
+		Bucket: s3.bucket,
+		Object: s3.prefix + id,
+	})
+	if err != nil {
+		return Remote.Entry{}, toError(err)
+	}
+
+	return Remote.NotifySaved(s3, newId)
+}
+
+// tree returns the ids of every object below the folder id, id included.
+func (s3 *S3) tree(id string) ([]string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ids := []string{}
+	for object := range s3.client.ListObjects(ctx, s3.bucket, minio.ListObjectsOptions{Prefix: s3.prefix + id, Recursive: true}) {
+		if object.Err != nil {
+			return nil, toError(object.Err)
+		}
+		ids = append(ids, strings.TrimPrefix(object.Key, s3.prefix))
+	}
+	return ids, nil
+}
+
+func (s3 *S3) toEntry(object minio.ObjectInfo) Remote.Entry {
+	id := strings.TrimPrefix(object.Key, s3.prefix)
+	entry := Remote.Entry{
+		ID:           id,
+		Name:         path.Base(id),
+		ParentID:     parentId(id),
+		IsDir:        strings.HasSuffix(id, "/"),
+		MimeType:     object.ContentType,
+		Size:         object.Size,
+		CreatedTime:  object.LastModified.UTC().Format(time.RFC3339Nano),
+		ModifiedTime: object.LastModified.UTC().Format(time.RFC3339Nano),
+		Properties:   fromMetadata(object.UserMetadata),
+	}
+
+	if entry.IsDir {
+		entry.MimeType = FolderMimeType
+	}
+
+	// ETag is the content md5, except for multipart uploads
+	etag := strings.Trim(object.ETag, "\"")
+	if !entry.IsDir && len(etag) == 32 {
+		entry.Md5 = etag
+	}
+
+	return entry
+}
+
+// parentId returns the folder id containing id, "" for the root. Unlike
+// Remote.ParentKey, folder ids end with "/".
+func parentId(id string) string {
+	parent := Remote.ParentKey(strings.TrimSuffix(id, "/"))
+	if parent == "" {
+		return ""
+	}
+	return parent + "/"
+}
+
+func toError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	code := minio.ToErrorResponse(err).Code
+	if code == "NoSuchKey" || code == "NotFound" {
+		return fmt.Errorf("%w: %v", Remote.ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package S3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/adapters/sqlite"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func init() {
+	// Events are saved on the worktree by SaveRemoteInfo, not needed here
+	go func() {
+		for range Remote.Events {
+		}
+	}()
+}
+
+// getenv returns the environment variable name, or value when it's unset.
+func getenv(name string, value string) string {
+	if env := os.Getenv(name); env != "" {
+		return env
+	}
+	return value
+}
+
+// setup connects to the MinIO server on SUPERPOSE_S3_ENDPOINT, e.g.
+// localhost:9000, and configures a temporary watched dir with its own
+// database. Objects are saved under a prefix of their own, removed once
+// the test is over.
+func setup(t *testing.T) (*S3, string) {
+	t.Helper()
+
+	configs := ConfigFile.S3{
+		Endpoint:  os.Getenv("SUPERPOSE_S3_ENDPOINT"),
+		Bucket:    getenv("SUPERPOSE_S3_BUCKET", "superpose"),
+		Prefix:    fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		AccessKey: getenv("SUPERPOSE_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: getenv("SUPERPOSE_S3_SECRET_KEY", "minioadmin"),
+		Insecure:  true,
+	}
+	if configs.Endpoint == "" {
+		t.Skip("SUPERPOSE_S3_ENDPOINT isn't set")
+	}
+
+	client, err := minio.New(configs.Endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(configs.AccessKey, configs.SecretKey, ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.BucketExists(context.Background(), configs.Bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		err = client.MakeBucket(context.Background(), configs.Bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watched := t.TempDir()
+	ConfigFile.Configs = ConfigFile.ConfigsStruct{
+		DbPath:     filepath.Join(t.TempDir(), "superpose.db"),
+		WatchPaths: []ConfigFile.WatchPath{{Path: watched}},
+		S3:         configs,
+	}
+	sqlite.Connect()
+	if err = repositories.SetScope(ConfigFile.RemoteScope()); err != nil {
+		t.Fatal(err)
+	}
+
+	s3, err := NewS3(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ids, _ := s3.tree("")
+		for _, id := range ids {
+			s3.client.RemoveObject(context.Background(), s3.bucket, s3.prefix+id, minio.RemoveObjectOptions{})
+		}
+	})
+
+	return s3, watched
+}
+
+func TestRoundTrip(t *testing.T) {
+	s3, watched := setup(t)
+
+	path := filepath.Join(watched, "dir", "file.txt")
+	content := []byte("superpose\n")
+	changedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, changedAt, changedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := Remote.Send(s3, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md5, _ := utils.Md5(bytes.NewReader(content))
+	if entry.Size != int64(len(content)) || entry.Md5 != md5 || entry.Properties["fullPath"] != path {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	// Sending it again unchanged only refreshes its metadata
+	if !Remote.SameContent(path, entry) {
+		t.Fatalf("%q should have the same content of %+v", path, entry)
+	}
+	if _, err = Remote.Send(s3, path); err != nil {
+		t.Fatal(err)
+	}
+
+	movedPath := filepath.Join(watched, "moved", "file.txt")
+	entry, err = s3.Move(entry, movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != Remote.Key(movedPath) || entry.Properties["fullPath"] != movedPath {
+		t.Fatalf("unexpected moved entry %+v", entry)
+	}
+	if _, err = s3.Stat(path); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Stat of the old path returned %v, want ErrNotFound", err)
+	}
+
+	// Another machine downloads it to the same path
+	if err = Remote.Materialize(s3, entry); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := os.ReadFile(movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded %q, want %q", downloaded, content)
+	}
+	info, err := os.Stat(movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode is %04o, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(changedAt) {
+		t.Errorf("mtime is %s, want %s", info.ModTime(), changedAt)
+	}
+
+	parent, err := s3.Stat(filepath.Dir(movedPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s3.Delete(parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s3.Get(entry.ID); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Get after deleting its folder returned %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,43 @@
+package S3
+
+import (
+	"net/url"
+	"strings"
+)
+
+// propertyNames restores the case of properties names, since S3 metadata
+// keys are case insensitive and come back as "Fullpath", "Changedat"...
+var propertyNames = map[string]string{
+	"fullpath":  "fullPath",
+	"watcher":   "watcher",
+	"relpath":   "relPath",
+	"mode":      "mode",
+	"changedat": "changedAt",
+}
+
+// toMetadata returns properties as x-amz-meta-* values. Values are escaped
+// because headers only carry ASCII, and paths may not.
+func toMetadata(properties map[string]string) map[string]string {
+	metadata := map[string]string{}
+	for name, value := range properties {
+		metadata[strings.ToLower(name)] = url.QueryEscape(value)
+	}
+	return metadata
+}
+
+func fromMetadata(metadata map[string]string) map[string]string {
+	properties := map[string]string{}
+	for name, value := range metadata {
+		name = strings.ToLower(name)
+		if propertyName, ok := propertyNames[name]; ok {
+			name = propertyName
+		}
+
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			unescaped = value
+		}
+		properties[name] = unescaped
+	}
+	return properties
+}
@@ -0,0 +1,542 @@
+package SFTP
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/services/EchoGuard"
+	"superpose-sync/services/Remote"
+	"superpose-sync/utils"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	RemoteName     = "sftp"
+	FolderMimeType = "inode/directory"
+)
+
+var ErrNoHost = errors.New("sftp: host is not configured")
+
+// SFTP syncs the watched dirs to a directory on an SSH host. Permission
+// bits and mtime are kept natively by the remote filesystem, and the path
+// saved on Drive appProperties is the path of the entry below root.
+type SFTP struct {
+	address   string
+	sshConfig *ssh.ClientConfig
+	root      string
+
+	mutex sync.Mutex
+	conn  *sftp.Client
+	// lost is set when the connection of conn was lost, the next call
+	// dials again
+	lost bool
+}
+
+var _ Remote.Remote = (*SFTP)(nil)
+
+func init() {
+	Remote.Register(RemoteName, func() (Remote.Remote, error) {
+		return NewSFTP(ConfigFile.Configs.SFTP)
+	})
+}
+
+func NewSFTP(configs ConfigFile.SFTP) (*SFTP, error) {
+	if configs.Host == "" {
+		return nil, ErrNoHost
+	}
+
+	sshConfig, err := clientConfig(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	address := configs.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "22")
+	}
+
+	s := &SFTP{address: address, sshConfig: sshConfig, root: path.Clean(configs.Root)}
+	err = s.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.conn.MkdirAll(s.root)
+	if err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// dial connects to the host. s.mutex must be held, or s not shared yet.
+func (s *SFTP) dial() error {
+	log.Printf("Initiating SFTP on %s@%s", s.sshConfig.User, s.address)
+	conn, err := ssh.Dial("tcp", s.address, s.sshConfig)
+	if err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = client
+	s.lost = false
+	go func() {
+		conn.Wait()
+		s.connectionLost(client)
+	}()
+	return nil
+}
+
+// client returns the SFTP client, dialing again when the connection was
+// lost. If it can't, the lost client is returned: its calls fail and the
+// queue retries them later.
+func (s *SFTP) client() *sftp.Client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lost {
+		s.conn.Close()
+		err := s.dial()
+		if err != nil {
+			log.Printf("error reconnecting to %s: %v", s.address, err)
+		}
+	}
+	return s.conn
+}
+
+// connectionLost makes the next call dial again, unless client was
+// already replaced.
+func (s *SFTP) connectionLost(client *sftp.Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == client {
+		s.lost = true
+	}
+}
+
+func clientConfig(configs ConfigFile.SFTP) (*ssh.ClientConfig, error) {
+	auths := []ssh.AuthMethod{}
+	if configs.KeyPath != "" {
+		keyPath, err := utils.GetAbsPath(configs.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if configs.Password != "" {
+		auths = append(auths, ssh.Password(configs.Password))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !configs.InsecureIgnoreHostKey {
+		knownHostsPath := configs.KnownHosts
+		if knownHostsPath == "" {
+			knownHostsPath = "~/.ssh/known_hosts"
+		}
+		knownHostsPath, err := utils.GetAbsPath(knownHostsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		hostKeyCallback, err = knownhosts.New(knownHostsPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ssh.ClientConfig{
+		User:            configs.User,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}, nil
+}
+
+func (s *SFTP) Name() string {
+	return RemoteName
+}
+
+func (s *SFTP) RootID() string {
+	return ""
+}
+
+func (s *SFTP) Stat(localPath string) (Remote.Entry, error) {
+	return s.Get(Remote.Key(localPath))
+}
+
+// Get returns the entry of id. Symlinks are returned as themselves, but
+// root is followed, it may link to where files are saved.
+func (s *SFTP) Get(id string) (Remote.Entry, error) {
+	stat := s.client().Lstat
+	if id == s.RootID() {
+		stat = s.client().Stat
+	}
+
+	info, err := stat(s.absPath(id))
+	if err != nil {
+		return Remote.Entry{}, s.toError(err)
+	}
+	return s.toEntry(id, info)
+}
+
+func (s *SFTP) List(id string) ([]Remote.Entry, error) {
+	infos, err := s.client().ReadDir(s.absPath(id))
+	if err != nil {
+		return nil, s.toError(err)
+	}
+
+	entries := make([]Remote.Entry, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), EchoGuard.TempPrefix) {
+			continue
+		}
+		entry, err := s.toEntry(path.Join(id, info.Name()), info)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *SFTP) Upload(localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	if info.IsDir() {
+		return s.Mkdir(localPath)
+	}
+
+	_, err = s.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return s.put(Remote.Key(localPath), localPath, info)
+}
+
+func (s *SFTP) Update(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return s.put(entry.ID, localPath, info)
+}
+
+func (s *SFTP) Download(entry Remote.Entry, w io.Writer) error {
+	if entry.IsDir {
+		return Remote.ErrNotDownloadable
+	}
+
+	file, err := s.client().Open(s.absPath(entry.ID))
+	if err != nil {
+		return s.toError(err)
+	}
+	defer file.Close()
+
+	_, err = file.WriteTo(w)
+	return err
+}
+
+func (s *SFTP) Mkdir(localPath string) (Remote.Entry, error) {
+	return Remote.MkdirAll(s, localPath, s.mkdir)
+}
+
+// mkdir creates the dir id, its parent already exists.
+func (s *SFTP) mkdir(id string, localPath string) (Remote.Entry, error) {
+	entry, err := s.Get(id)
+	if err == nil || !errors.Is(err, Remote.ErrNotFound) {
+		return entry, err
+	}
+
+	err = s.client().Mkdir(s.absPath(id))
+	if err != nil {
+		// Created meanwhile by another upload
+		if entry, getErr := s.Get(id); getErr == nil && entry.IsDir {
+			return entry, nil
+		}
+		return Remote.Entry{}, s.toError(err)
+	}
+
+	if info, err := os.Stat(localPath); err == nil {
+		err = s.applyMetadata(id, Remote.Properties(localPath, info))
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+	}
+
+	return Remote.NotifySaved(s, id)
+}
+
+// Delete removes entry, and everything inside it when it's a folder.
+func (s *SFTP) Delete(entry Remote.Entry) error {
+	log.Println("Remove: ", entry.ID)
+
+	paths := []string{}
+	walker := s.client().Walk(s.absPath(entry.ID))
+	for walker.Step() {
+		if walker.Err() != nil {
+			return s.toError(walker.Err())
+		}
+		paths = append(paths, walker.Path())
+	}
+
+	// Content is removed before its folder
+	for i := len(paths) - 1; i >= 0; i-- {
+		err := s.client().Remove(paths[i])
+		if err != nil {
+			return s.toError(err)
+		}
+	}
+
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+	return nil
+}
+
+func (s *SFTP) Move(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	newId := Remote.Key(localPath)
+	_, err := s.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = s.rename(s.absPath(entry.ID), s.absPath(newId))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+
+	moved, err := Remote.NotifySaved(s, newId)
+	if err != nil || !moved.IsDir {
+		return moved, err
+	}
+
+	// The content of a folder has new ids, too
+	err = Remote.WalkTree(s, newId, func(child Remote.Entry) error {
+		Remote.Notify(Remote.Event{Entry: child, Action: Remote.Saved})
+		return nil
+	})
+	return moved, err
+}
+
+// SetMetadata applies mode and changedAt of properties on the remote file.
+// Path properties come from where the entry is saved, so they are ignored,
+// and so is the metadata of symlinks, it would be applied on their target.
+func (s *SFTP) SetMetadata(entry Remote.Entry, properties map[string]string) (Remote.Entry, error) {
+	if _, ok := entry.Properties["symlink"]; !ok {
+		err := s.applyMetadata(entry.ID, properties)
+		if err != nil {
+			return Remote.Entry{}, err
+		}
+	}
+
+	return Remote.NotifySaved(s, entry.ID)
+}
+
+// StartCursor isn't supported, changes made by other machines are found
+// by rescanning the host.
+func (s *SFTP) StartCursor() (string, error) {
+	return "", Remote.ErrNotSupported
+}
+
+func (s *SFTP) Changes(cursor string) ([]Remote.Change, string, error) {
+	return nil, "", Remote.ErrNotSupported
+}
+
+// put copies localPath content to id. The content is written to a
+// temporary file and renamed, so other machines never read a partial file.
+// Preserved symlinks are created as symlinks.
+func (s *SFTP) put(id string, localPath string, info os.FileInfo) (Remote.Entry, error) {
+	if utils.IsSymlink(info) {
+		return s.putSymlink(id, localPath)
+	}
+
+	src, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
+	}
+	defer src.Close()
+
+	absPath := s.absPath(id)
+	tmpPath := path.Join(path.Dir(absPath), fmt.Sprintf("%s%d", EchoGuard.TempPrefix, time.Now().UnixNano()))
+
+	dest, err := s.client().OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return Remote.Entry{}, s.toError(err)
+	}
+	defer s.client().Remove(tmpPath)
+
+	_, err = dest.ReadFrom(src)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = s.client().Chmod(tmpPath, info.Mode().Perm())
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	err = s.client().Chtimes(tmpPath, info.ModTime(), info.ModTime())
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	err = s.rename(tmpPath, absPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return Remote.NotifySaved(s, id)
+}
+
+// putSymlink creates on id a symlink to the target of localPath.
+func (s *SFTP) putSymlink(id string, localPath string) (Remote.Entry, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	absPath := s.absPath(id)
+	tmpPath := path.Join(path.Dir(absPath), fmt.Sprintf("%s%d", EchoGuard.TempPrefix, time.Now().UnixNano()))
+	err = s.client().Symlink(target, tmpPath)
+	if err != nil {
+		return Remote.Entry{}, s.toError(err)
+	}
+	defer s.client().Remove(tmpPath)
+
+	err = s.rename(tmpPath, absPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return Remote.NotifySaved(s, id)
+}
+
+// rename replaces newPath, which plain SFTP renames refuse to do.
+func (s *SFTP) rename(oldPath string, newPath string) error {
+	err := s.client().PosixRename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+
+	if _, statErr := s.client().Lstat(newPath); statErr == nil {
+		s.client().Remove(newPath)
+	}
+	return s.toError(s.client().Rename(oldPath, newPath))
+}
+
+func (s *SFTP) applyMetadata(id string, properties map[string]string) error {
+	absPath := s.absPath(id)
+
+	if mode, ok := parseMode(properties["mode"]); ok {
+		err := s.client().Chmod(absPath, mode)
+		if err != nil {
+			return s.toError(err)
+		}
+	}
+
+	changedAt, ok := Remote.ChangedAt(Remote.Entry{Properties: properties})
+	if ok {
+		return s.toError(s.client().Chtimes(absPath, changedAt, changedAt))
+	}
+	return nil
+}
+
+func (s *SFTP) absPath(id string) string {
+	return path.Join(s.root, path.Clean("/"+id))
+}
+
+// toEntry returns the entry of id, with the same properties saved on
+// Drive appProperties, taken from the remote file itself.
+func (s *SFTP) toEntry(id string, info os.FileInfo) (Remote.Entry, error) {
+	modTime := info.ModTime()
+	entry := Remote.Entry{
+		ID:           id,
+		Name:         path.Base(id),
+		ParentID:     Remote.ParentKey(id),
+		IsDir:        info.IsDir(),
+		Size:         info.Size(),
+		CreatedTime:  modTime.UTC().Format(time.RFC3339Nano),
+		ModifiedTime: modTime.UTC().Format(time.RFC3339Nano),
+		Properties: map[string]string{
+			"fullPath":  Remote.KeyPath(id),
+			"mode":      fmt.Sprintf("%04o", info.Mode().Perm()),
+			"changedAt": modTime.String(),
+		},
+	}
+
+	if utils.IsSymlink(info) {
+		target, err := s.client().ReadLink(s.absPath(id))
+		if err != nil {
+			return Remote.Entry{}, s.toError(err)
+		}
+		entry.Properties["symlink"] = target
+		entry.Size = int64(len(target))
+	}
+
+	if entry.IsDir {
+		entry.MimeType = FolderMimeType
+		entry.Size = 0
+	} else if entry.MimeType = mime.TypeByExtension(path.Ext(id)); entry.MimeType == "" {
+		entry.MimeType = "application/octet-stream"
+	}
+
+	return entry, nil
+}
+
+func parseMode(strMode string) (os.FileMode, bool) {
+	var mode uint32
+	_, err := fmt.Sscanf(strMode, "%o", &mode)
+	if err != nil || mode > uint32(os.ModePerm) {
+		return 0, false
+	}
+	return os.FileMode(mode), true
+}
+
+func (s *SFTP) toError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %v", Remote.ErrNotFound, err)
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		s.mutex.Lock()
+		s.lost = true
+		s.mutex.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package SFTP
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/adapters/sqlite"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+func init() {
+	// Events are saved on the worktree by SaveRemoteInfo, not needed here
+	go func() {
+		for range Remote.Events {
+		}
+	}()
+}
+
+// setup serves a temporary dir with an in-process SFTP server and
+// configures a temporary watched dir, with its own database.
+func setup(t *testing.T) (*SFTP, string, string) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	watched := t.TempDir()
+	root := t.TempDir()
+	ConfigFile.Configs = ConfigFile.ConfigsStruct{
+		DbPath:     filepath.Join(t.TempDir(), "superpose.db"),
+		WatchPaths: []ConfigFile.WatchPath{{Path: watched}},
+		SFTP:       ConfigFile.SFTP{Host: "localhost", User: "superpose", Root: root},
+	}
+	sqlite.Connect()
+	if err = repositories.SetScope(ConfigFile.RemoteScope()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &SFTP{address: "localhost:22", root: root, conn: client}, watched, root
+}
+
+func writeFile(t *testing.T, path string, content []byte, changedAt time.Time) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, changedAt, changedAt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	s, watched, root := setup(t)
+
+	path := filepath.Join(watched, "dir", "file.txt")
+	changedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeFile(t, path, []byte("superpose\n"), changedAt)
+
+	entry, err := Remote.Send(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Properties["mode"] != "0640" || entry.Properties["fullPath"] != path {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	// Updates replace the content and keep mode and mtime
+	content := []byte("superpose, updated\n")
+	writeFile(t, path, content, changedAt.Add(time.Hour))
+	entry, err = Remote.Send(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(filepath.Join(root, entry.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, content) || entry.Size != int64(len(content)) {
+		t.Errorf("saved %q, want %q", saved, content)
+	}
+	info, err := os.Stat(filepath.Join(root, entry.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(changedAt.Add(time.Hour)) {
+		t.Errorf("saved with mode %04o and mtime %s", info.Mode().Perm(), info.ModTime())
+	}
+
+	movedPath := filepath.Join(watched, "moved", "file.txt")
+	entry, err = s.Move(entry, movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != Remote.Key(movedPath) || entry.Properties["fullPath"] != movedPath {
+		t.Fatalf("unexpected moved entry %+v", entry)
+	}
+	if _, err = s.Stat(path); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Stat of the old path returned %v, want ErrNotFound", err)
+	}
+
+	if err = Remote.Materialize(s, entry); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := os.ReadFile(movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded %q, want %q", downloaded, content)
+	}
+
+	parent, err := s.Stat(filepath.Dir(movedPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete(parent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Get(entry.ID); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Get after deleting its folder returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	s, watched, root := setup(t)
+
+	link := filepath.Join(watched, "link")
+	if err := os.Symlink("target/file.txt", link); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := Remote.Send(s, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Properties["symlink"] != "target/file.txt" {
+		t.Fatalf("the symlink property is missing on %+v", entry)
+	}
+	target, err := os.Readlink(filepath.Join(root, entry.ID))
+	if err != nil || target != "target/file.txt" {
+		t.Errorf("saved as a link to %q (%v), want %q", target, err, "target/file.txt")
+	}
+
+	// Sending it again only refreshes its metadata, which is kept
+	entry, err = Remote.SendMetadata(s, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Properties["symlink"] != "target/file.txt" {
+		t.Fatalf("the symlink property was dropped from %+v", entry)
+	}
+
+	if err = os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err = Remote.Materialize(s, entry); err != nil {
+		t.Fatal(err)
+	}
+	target, err = os.Readlink(link)
+	if err != nil || target != "target/file.txt" {
+		t.Errorf("downloaded as a link to %q (%v), want %q", target, err, "target/file.txt")
+	}
+}
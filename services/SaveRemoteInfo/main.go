@@ -0,0 +1,59 @@
+package SaveRemoteInfo
+
+import (
+	"log"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+)
+
+// StartListener saves every remote event on the worktree cache. The returned
+// channel is closed once Remote.Events is closed and drained.
+func StartListener() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case event, ok := <-Remote.Events:
+				if !ok {
+					log.Println("!ok: ", ok)
+					return
+				}
+
+				path := repositories.Path{
+					ID:        event.Entry.ID,
+					Name:      event.Entry.Name,
+					MimeType:  event.Entry.MimeType,
+					ChangedAt: event.Entry.ModifiedTime,
+					CreatedAt: event.Entry.CreatedTime,
+					IsDir:     getIsDir(event.Entry),
+					ParentID:  event.Entry.ParentID,
+					FullPath:  event.LocalPath,
+					Md5:       event.Entry.Md5,
+					Size:      event.Entry.Size,
+				}
+				if path.FullPath == "" {
+					path.FullPath = ConfigFile.LocalPathFromProperties(event.Entry.Properties)
+				}
+
+				log.Println("event.Action: ", event.Action)
+				log.Println("path: ", path.String())
+
+				if event.Action == Remote.Removed {
+					repositories.Delete(path)
+				} else {
+					repositories.Upsert(path)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+func getIsDir(entry Remote.Entry) int {
+	if entry.IsDir {
+		return 1
+	}
+	return 0
+}
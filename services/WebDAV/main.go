@@ -0,0 +1,348 @@
+package WebDAV
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/services/Remote"
+)
+
+const (
+	RemoteName     = "webdav"
+	FolderMimeType = "httpd/unix-directory"
+)
+
+var ErrNoURL = errors.New("webdav: url is not configured")
+
+// WebDAV syncs the watched dirs to a WebDAV server, like Nextcloud or
+// ownCloud. The metadata saved on Drive appProperties is saved as dead
+// properties, and entries are identified by their path relative to url.
+type WebDAV struct {
+	client   *http.Client
+	baseURL  *url.URL
+	user     string
+	password string
+}
+
+var _ Remote.Remote = (*WebDAV)(nil)
+
+func init() {
+	Remote.Register(RemoteName, func() (Remote.Remote, error) {
+		return NewWebDAV(ConfigFile.Configs.WebDAV)
+	})
+}
+
+func NewWebDAV(configs ConfigFile.WebDAV) (*WebDAV, error) {
+	if configs.URL == "" {
+		return nil, ErrNoURL
+	}
+
+	baseURL, err := url.Parse(strings.TrimSuffix(configs.URL, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Initiating WebDAV on %q", baseURL.Redacted())
+	webDAV := &WebDAV{
+		client:   &http.Client{},
+		baseURL:  baseURL,
+		user:     configs.User,
+		password: configs.Password,
+	}
+
+	_, err = webDAV.Get(webDAV.RootID())
+	if err != nil {
+		return nil, err
+	}
+	return webDAV, nil
+}
+
+func (webDAV *WebDAV) Name() string {
+	return RemoteName
+}
+
+func (webDAV *WebDAV) RootID() string {
+	return ""
+}
+
+func (webDAV *WebDAV) Stat(localPath string) (Remote.Entry, error) {
+	return webDAV.Get(Remote.Key(localPath))
+}
+
+func (webDAV *WebDAV) Get(id string) (Remote.Entry, error) {
+	entries, err := webDAV.propfind(id, "0")
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	if len(entries) == 0 {
+		return Remote.Entry{}, Remote.ErrNotFound
+	}
+	return entries[0], nil
+}
+
+func (webDAV *WebDAV) List(id string) ([]Remote.Entry, error) {
+	entries, err := webDAV.propfind(id, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]Remote.Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ID != id {
+			children = append(children, entry)
+		}
+	}
+	return children, nil
+}
+
+func (webDAV *WebDAV) Upload(localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	if info.IsDir() {
+		return webDAV.Mkdir(localPath)
+	}
+
+	_, err = webDAV.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return webDAV.put(Remote.Key(localPath), localPath, info)
+}
+
+func (webDAV *WebDAV) Update(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	info, err := Remote.LocalInfo(localPath)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	return webDAV.put(entry.ID, localPath, info)
+}
+
+func (webDAV *WebDAV) Download(entry Remote.Entry, w io.Writer) error {
+	if entry.IsDir {
+		return Remote.ErrNotDownloadable
+	}
+
+	response, err := webDAV.do(http.MethodGet, entry.ID, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	_, err = io.Copy(w, response.Body)
+	return err
+}
+
+func (webDAV *WebDAV) Mkdir(localPath string) (Remote.Entry, error) {
+	return Remote.MkdirAll(webDAV, localPath, webDAV.mkdir)
+}
+
+// mkdir creates the collection id, its parent already exists.
+func (webDAV *WebDAV) mkdir(id string, localPath string) (Remote.Entry, error) {
+	entry, err := webDAV.Get(id)
+	if err == nil || !errors.Is(err, Remote.ErrNotFound) {
+		return entry, err
+	}
+
+	response, err := webDAV.do("MKCOL", id, nil, nil)
+	if err != nil {
+		// Created meanwhile by another upload
+		if entry, getErr := webDAV.Get(id); getErr == nil && entry.IsDir {
+			return entry, nil
+		}
+		return Remote.Entry{}, err
+	}
+	response.Body.Close()
+
+	properties := ConfigFile.PathProperties(localPath)
+	if info, err := os.Stat(localPath); err == nil {
+		properties = Remote.Properties(localPath, info)
+	}
+
+	return webDAV.SetMetadata(Remote.Entry{ID: id}, properties)
+}
+
+func (webDAV *WebDAV) Delete(entry Remote.Entry) error {
+	log.Println("Remove: ", entry.ID)
+	response, err := webDAV.do(http.MethodDelete, entry.ID, nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+	return nil
+}
+
+func (webDAV *WebDAV) Move(entry Remote.Entry, localPath string) (Remote.Entry, error) {
+	newId := Remote.Key(localPath)
+	_, err := webDAV.Mkdir(filepath.Dir(localPath))
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	response, err := webDAV.do("MOVE", entry.ID, nil, map[string]string{
+		"Destination": webDAV.url(newId),
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	response.Body.Close()
+	Remote.Notify(Remote.Event{Entry: entry, Action: Remote.Removed})
+
+	// Paths saved on properties must follow the entry, and its content
+	// when it's a collection
+	moved, err := webDAV.refreshPath(newId, localPath)
+	if err != nil || !moved.IsDir {
+		return moved, err
+	}
+
+	err = Remote.WalkTree(webDAV, newId, func(child Remote.Entry) error {
+		rel := strings.TrimPrefix(child.ID, newId+"/")
+		_, err := webDAV.refreshPath(child.ID, filepath.Join(localPath, filepath.FromSlash(rel)))
+		return err
+	})
+	return moved, err
+}
+
+func (webDAV *WebDAV) SetMetadata(entry Remote.Entry, properties map[string]string) (Remote.Entry, error) {
+	response, err := webDAV.do("PROPPATCH", entry.ID, strings.NewReader(proppatchBody(properties)), map[string]string{
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	response.Body.Close()
+
+	return Remote.NotifySaved(webDAV, entry.ID)
+}
+
+// StartCursor isn't supported, changes made by other machines are found
+// by rescanning the server.
+func (webDAV *WebDAV) StartCursor() (string, error) {
+	return "", Remote.ErrNotSupported
+}
+
+func (webDAV *WebDAV) Changes(cursor string) ([]Remote.Change, string, error) {
+	return nil, "", Remote.ErrNotSupported
+}
+
+func (webDAV *WebDAV) put(id string, localPath string, info os.FileInfo) (Remote.Entry, error) {
+	file, err := Remote.OpenLocal(localPath)
+	if err != nil {
+		log.Printf("error opening %q: %v", localPath, err)
+		return Remote.Entry{}, err
+	}
+	defer file.Close()
+
+	request, err := webDAV.newRequest(http.MethodPut, id, file, nil)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	// Without it the content is sent chunked, which some servers refuse
+	request.ContentLength = info.Size()
+
+	response, err := webDAV.send(request)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+	response.Body.Close()
+
+	return webDAV.SetMetadata(Remote.Entry{ID: id}, Remote.Properties(localPath, info))
+}
+
+// refreshPath rewrites the path properties of id to localPath.
+func (webDAV *WebDAV) refreshPath(id string, localPath string) (Remote.Entry, error) {
+	entry, err := webDAV.Get(id)
+	if err != nil {
+		return Remote.Entry{}, err
+	}
+
+	properties := map[string]string{}
+	for name, value := range entry.Properties {
+		properties[name] = value
+	}
+	for name, value := range ConfigFile.PathProperties(localPath) {
+		properties[name] = value
+	}
+
+	return webDAV.SetMetadata(entry, properties)
+}
+
+// do sends a request to id, see send.
+func (webDAV *WebDAV) do(method string, id string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	request, err := webDAV.newRequest(method, id, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	return webDAV.send(request)
+}
+
+func (webDAV *WebDAV) newRequest(method string, id string, body io.Reader, headers map[string]string) (*http.Request, error) {
+	request, err := http.NewRequest(method, webDAV.url(id), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	if webDAV.user != "" {
+		request.SetBasicAuth(webDAV.user, webDAV.password)
+	}
+	return request, nil
+}
+
+// send fails on any status other than 2xx, a 404 is returned as
+// Remote.ErrNotFound.
+func (webDAV *WebDAV) send(request *http.Request) (*http.Response, error) {
+	response, err := webDAV.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		if response.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s %q", Remote.ErrNotFound, request.Method, request.URL.Path)
+		}
+		return nil, fmt.Errorf("webdav: %s %q: %s", request.Method, request.URL.Path, response.Status)
+	}
+
+	return response, nil
+}
+
+// url returns the absolute url of id, with each segment escaped.
+func (webDAV *WebDAV) url(id string) string {
+	segments := strings.Split(id, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return webDAV.baseURL.String() + strings.Join(segments, "/")
+}
+
+// id returns the id of an href returned by the server.
+func (webDAV *WebDAV) id(href string) (string, error) {
+	hrefURL, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+
+	hrefPath := strings.TrimSuffix(hrefURL.Path, "/") + "/"
+	if !strings.HasPrefix(hrefPath, webDAV.baseURL.Path) {
+		return "", fmt.Errorf("webdav: href %q outside %q", href, webDAV.baseURL.Path)
+	}
+	return strings.Trim(strings.TrimPrefix(hrefPath, webDAV.baseURL.Path), "/"), nil
+}
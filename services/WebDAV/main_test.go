@@ -0,0 +1,161 @@
+package WebDAV
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"superpose-sync/adapters/ConfigFile"
+	"superpose-sync/adapters/sqlite"
+	"superpose-sync/repositories"
+	"superpose-sync/services/Remote"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func init() {
+	// Events are saved on the worktree by SaveRemoteInfo, not needed here
+	go func() {
+		for range Remote.Events {
+		}
+	}()
+}
+
+// setup starts an in memory WebDAV server and configures a temporary
+// watched dir, with its own database.
+func setup(t *testing.T) (*WebDAV, string) {
+	t.Helper()
+
+	server := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(server.Close)
+
+	watched := t.TempDir()
+	ConfigFile.Configs = ConfigFile.ConfigsStruct{
+		DbPath:     filepath.Join(t.TempDir(), "superpose.db"),
+		WatchPaths: []ConfigFile.WatchPath{{Path: watched}},
+		WebDAV:     ConfigFile.WebDAV{URL: server.URL + "/dav"},
+	}
+	sqlite.Connect()
+	if err := repositories.SetScope(ConfigFile.RemoteScope()); err != nil {
+		t.Fatal(err)
+	}
+
+	webDAV, err := NewWebDAV(ConfigFile.Configs.WebDAV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webDAV, watched
+}
+
+func TestRoundTrip(t *testing.T) {
+	webDAV, watched := setup(t)
+
+	path := filepath.Join(watched, "dir", "file name.txt")
+	content := []byte("superpose\n")
+	changedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, changedAt, changedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := Remote.Send(webDAV, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Size != int64(len(content)) || entry.Properties["mode"] != "0640" {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	// Moved entries keep their content and get the new path properties
+	movedPath := filepath.Join(watched, "moved", "file name.txt")
+	entry, err = webDAV.Move(entry, movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != Remote.Key(movedPath) || entry.Properties["fullPath"] != movedPath {
+		t.Fatalf("unexpected moved entry %+v", entry)
+	}
+	if _, err = webDAV.Stat(path); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Stat of the old path returned %v, want ErrNotFound", err)
+	}
+
+	children, err := webDAV.List(entry.ParentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 || children[0].ID != entry.ID {
+		t.Errorf("List(%q) returned %+v", entry.ParentID, children)
+	}
+
+	if err = Remote.Materialize(webDAV, entry); err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := os.ReadFile(movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded %q, want %q", downloaded, content)
+	}
+	info, err := os.Stat(movedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode is %04o, want 0640", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(changedAt) {
+		t.Errorf("mtime is %s, want %s", info.ModTime(), changedAt)
+	}
+
+	if err = webDAV.Delete(entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = webDAV.Get(entry.ID); !errors.Is(err, Remote.ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	webDAV, watched := setup(t)
+
+	link := filepath.Join(watched, "link")
+	if err := os.Symlink("target/file.txt", link); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := Remote.Send(webDAV, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err = webDAV.Get(entry.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Properties["symlink"] != "target/file.txt" {
+		t.Fatalf("the symlink property is missing on %+v", entry)
+	}
+
+	if err = os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err = Remote.Materialize(webDAV, entry); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(link)
+	if err != nil || target != "target/file.txt" {
+		t.Errorf("downloaded as a link to %q (%v), want %q", target, err, "target/file.txt")
+	}
+}
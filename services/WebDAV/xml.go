@@ -0,0 +1,162 @@
+package WebDAV
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"superpose-sync/services/Remote"
+	"time"
+)
+
+// namespace of the dead properties keeping entries metadata
+const namespace = "urn:superpose-sync"
+
+// propertyNames are the properties saved as dead properties, the same
+// saved on Drive appProperties.
+var propertyNames = []string{"fullPath", "watcher", "relPath", "mode", "changedAt", "symlink"}
+
+type multistatus struct {
+	Responses []response `xml:"DAV: response"`
+}
+
+type response struct {
+	Href      string     `xml:"DAV: href"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Prop struct {
+		Props []prop `xml:",any"`
+	} `xml:"DAV: prop"`
+	Status string `xml:"DAV: status"`
+}
+
+type prop struct {
+	XMLName  xml.Name
+	Value    string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// propfind returns id, and its children when depth is "1".
+func (webDAV *WebDAV) propfind(id string, depth string) ([]Remote.Entry, error) {
+	response, err := webDAV.do("PROPFIND", id, strings.NewReader(propfindBody()), map[string]string{
+		"Content-Type": "application/xml; charset=utf-8",
+		"Depth":        depth,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusMultiStatus {
+		return nil, errors.New("webdav: PROPFIND " + id + ": " + response.Status)
+	}
+
+	var result multistatus
+	err = xml.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Remote.Entry, 0, len(result.Responses))
+	for _, response := range result.Responses {
+		entryId, err := webDAV.id(response.Href)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, toEntry(entryId, response))
+	}
+
+	// The requested id comes first
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ID == id && entries[j].ID != id
+	})
+	return entries, nil
+}
+
+func toEntry(id string, response response) Remote.Entry {
+	entry := Remote.Entry{
+		ID:         id,
+		Name:       path.Base(id),
+		ParentID:   Remote.ParentKey(id),
+		Properties: map[string]string{},
+	}
+
+	for _, propstat := range response.Propstats {
+		if !strings.Contains(propstat.Status, " 200 ") {
+			continue
+		}
+
+		for _, prop := range propstat.Prop.Props {
+			if prop.XMLName.Space == namespace {
+				entry.Properties[prop.XMLName.Local] = prop.Value
+				continue
+			}
+
+			switch prop.XMLName.Local {
+			case "resourcetype":
+				entry.IsDir = strings.Contains(prop.InnerXML, "collection")
+			case "getcontenttype":
+				entry.MimeType = prop.Value
+			case "getcontentlength":
+				entry.Size, _ = strconv.ParseInt(strings.TrimSpace(prop.Value), 10, 64)
+			case "creationdate":
+				entry.CreatedTime = formatTime(prop.Value, time.RFC3339)
+			case "getlastmodified":
+				entry.ModifiedTime = formatTime(prop.Value, http.TimeFormat)
+			}
+		}
+	}
+
+	if entry.IsDir {
+		entry.MimeType = FolderMimeType
+	}
+	if entry.CreatedTime == "" {
+		entry.CreatedTime = entry.ModifiedTime
+	}
+	return entry
+}
+
+func formatTime(value string, layout string) string {
+	t, err := time.Parse(layout, strings.TrimSpace(value))
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func propfindBody() string {
+	body := bytes.Buffer{}
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<D:propfind xmlns:D="DAV:" xmlns:S="` + namespace + `"><D:prop>`)
+	body.WriteString(`<D:resourcetype/><D:getcontenttype/><D:getcontentlength/><D:creationdate/><D:getlastmodified/>`)
+	for _, name := range propertyNames {
+		body.WriteString(`<S:` + name + `/>`)
+	}
+	body.WriteString(`</D:prop></D:propfind>`)
+	return body.String()
+}
+
+func proppatchBody(properties map[string]string) string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	body := bytes.Buffer{}
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	body.WriteString(`<D:propertyupdate xmlns:D="DAV:" xmlns:S="` + namespace + `"><D:set><D:prop>`)
+	for _, name := range names {
+		body.WriteString(`<S:` + name + `>`)
+		xml.EscapeText(&body, []byte(properties[name]))
+		body.WriteString(`</S:` + name + `>`)
+	}
+	body.WriteString(`</D:prop></D:set></D:propertyupdate>`)
+	return body.String()
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+)
+
+// Md5 returns the hex md5 of what is read from r.
+func Md5(r io.Reader) (string, error) {
+	hash := md5.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
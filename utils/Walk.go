@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IsSymlink reports whether info, from os.Lstat, is a symbolic link.
+func IsSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// Walk is filepath.Walk following symlinks when follow is true: fn receives
+// the info of their target, or of the link itself when it's broken, and
+// linked dirs are walked too, unless they link to one of their own parents.
+func Walk(root string, follow bool, fn filepath.WalkFunc) error {
+	if !follow {
+		return filepath.Walk(root, fn)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(root, info, parents(root), fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// parents returns the info of every dir above path, so links to them are
+// found even when the walk starts below.
+func parents(path string) []os.FileInfo {
+	infos := []os.FileInfo{}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if info, err := os.Stat(dir); err == nil {
+			infos = append(infos, info)
+		}
+		if dir == filepath.Dir(dir) {
+			return infos
+		}
+	}
+}
+
+func walk(path string, info os.FileInfo, parents []os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	for _, parent := range parents {
+		if os.SameFile(parent, info) {
+			log.Printf("skipping %q: symlink loop", path)
+			return nil
+		}
+	}
+
+	names, err := readDirNames(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	parents = append(parents[:len(parents):len(parents)], info)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Lstat(filename)
+		if err == nil && IsSymlink(fileInfo) {
+			if target, err := os.Stat(filename); err == nil {
+				fileInfo = target
+			}
+		}
+
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		err = walk(filename, fileInfo, parents, fn)
+		if err != nil && (!fileInfo.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	file, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	names, err := file.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}